@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/temlioinc/go-switch"
 	"log"
 )
@@ -9,7 +10,7 @@ type OutboundManager struct {
 	*fsswitch.OutboundSocket
 }
 
-func NewOutboundManager(client *fsswitch.OutboundSocket) {
+func NewOutboundManager(ctx context.Context, client *fsswitch.OutboundSocket) {
 	log.Println("NewManager")
 	outbound := new(OutboundManager)
 	outbound.OutboundSocket = client
@@ -62,5 +63,6 @@ func (self *OutboundManager) OnCEC(ev *fsswitch.Event) {
 }
 
 func main() {
-	fsswitch.OutboundServer(":9001", NewOutboundManager)
+	server := fsswitch.NewOutboundServer(":9001", NewOutboundManager)
+	log.Fatal(server.ListenAndServe(context.Background()))
 }
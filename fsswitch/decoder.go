@@ -0,0 +1,240 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FrameDecoder turns a frame read from the socket into the Event handed to
+// event handlers. The frame Header holds the outer (envelope) headers and
+// Body its payload. Returning a nil Event drops the frame.
+type FrameDecoder func(frame *Event) (*Event, error)
+
+// UnknownContentTypeError is passed to the unknown frame callback for
+// frames whose Content-Type has no registered decoder.
+type UnknownContentTypeError struct {
+	ContentType string
+}
+
+func (u *UnknownContentTypeError) Error() string {
+	return fmt.Sprintf("Unsupported content type %q", u.ContentType)
+}
+
+// defaultDecoders returns the decoders every EventSocket starts with.
+func defaultDecoders() map[string]FrameDecoder {
+	return map[string]FrameDecoder{
+		"text/event-plain": decodePlainEvent,
+		"text/event-json":  decodeJSONEvent,
+		"text/event-xml":   decodeXMLEvent,
+	}
+}
+
+// RegisterContentType installs dec for frames of the given Content-Type,
+// replacing the decoder already registered for it, if any. Replies,
+// auth requests and disconnect notices are always handled by the socket.
+func (e *EventSocket) RegisterContentType(contentType string, dec FrameDecoder) {
+	e.mtx.Lock()
+	e.decoders[contentType] = dec
+	e.mtx.Unlock()
+}
+
+// OnUnknownFrame sets the function called with frames the socket could not
+// turn into events: frames with an unregistered Content-Type (err is an
+// *UnknownContentTypeError) and frames whose decoder failed. The read loop
+// carries on either way. Without a callback such frames are logged.
+func (e *EventSocket) OnUnknownFrame(fn func(frame *Event, err error)) {
+	e.mtx.Lock()
+	e.unknownFrame = fn
+	e.mtx.Unlock()
+}
+
+// decodeFrame runs the decoder registered for the frame's Content-Type and
+// delivers the resulting event.
+func (e *EventSocket) decodeFrame(contentType string, frame *Event) {
+	e.mtx.Lock()
+	dec := e.decoders[contentType]
+	unknown := e.unknownFrame
+	e.mtx.Unlock()
+	var (
+		ev  *Event
+		err error
+	)
+	if dec == nil {
+		err = &UnknownContentTypeError{ContentType: contentType}
+	} else if ev, err = dec(frame); err == nil {
+		if ev != nil {
+			e.deliverEvent(ev)
+		}
+		return
+	}
+	if unknown != nil {
+		unknown(frame, err)
+	} else {
+		e.logger.Printf("Dropping frame: %s", err)
+	}
+}
+
+// header is a header line as sent on the wire.
+type header struct {
+	name, value string
+}
+
+// readHeaders reads header lines up to the blank line ending them. Unlike
+// textproto it keeps names in the case FreeSWITCH sent them in, so
+// variable_sip_from_user doesn't turn into Variable_sip_from_user. At the
+// end of input it returns the headers read so far along with io.EOF.
+func readHeaders(r *bufio.Reader) ([]header, error) {
+	var hdrs []header
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if err != nil {
+				return hdrs, err
+			}
+			if len(hdrs) == 0 {
+				// Stray newline between frames.
+				continue
+			}
+			return hdrs, nil
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return hdrs, fmt.Errorf("Malformed header line: %q", line)
+		}
+		hdrs = append(hdrs, header{
+			name:  strings.TrimSpace(line[:i]),
+			value: strings.TrimLeft(line[i+1:], " \t"),
+		})
+		if err != nil {
+			return hdrs, err
+		}
+	}
+}
+
+// headerValue returns the first value of the header name, whatever its
+// case.
+func headerValue(hdrs []header, name string) string {
+	for _, h := range hdrs {
+		if strings.EqualFold(h.name, name) {
+			return h.value
+		}
+	}
+	return ""
+}
+
+// decodePlainEvent parses text/event-plain, where the body is a second set
+// of URL-encoded headers optionally followed by the event body.
+func decodePlainEvent(frame *Event) (*Event, error) {
+	reader := bufio.NewReader(strings.NewReader(frame.Body))
+	hdr, err := readHeaders(reader)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	ev := &Event{Header: make(map[string]string)}
+	if v := headerValue(hdr, "Content-Length"); v != "" {
+		length, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, length)
+		if _, err = io.ReadFull(reader, b); err != nil {
+			return nil, err
+		}
+		ev.Body = string(b)
+	}
+	copyHeaders(hdr, ev, true)
+	return ev, nil
+}
+
+// decodeJSONEvent parses text/event-json. The event body, if any, is sent
+// in the _body key. Arrays, sent for array headers, are kept in the
+// ARRAY:: encoding of plain events; numbers, booleans and objects are kept
+// as their JSON text.
+func decodeJSONEvent(frame *Event) (*Event, error) {
+	tmp := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(frame.Body), &tmp); err != nil {
+		return nil, err
+	}
+	ev := &Event{Header: make(map[string]string)}
+	for k, raw := range tmp {
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			ev.Header[k] = jsonString(raw)
+			continue
+		}
+		if len(items) == 0 {
+			ev.Header[k] = ""
+			continue
+		}
+		values := make([]string, len(items))
+		for i, item := range items {
+			values[i] = jsonString(item)
+		}
+		ev.Header[k] = joinValues(values)
+	}
+	if v := ev.Header["_body"]; v != "" {
+		ev.Body = v
+	}
+	delete(ev.Header, "_body")
+	return ev, nil
+}
+
+// jsonString returns a JSON string unquoted, null as "" and any other value
+// as its JSON text.
+func jsonString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	if text := strings.TrimSpace(string(raw)); text != "null" {
+		return text
+	}
+	return ""
+}
+
+// xmlEvent is the layout of text/event-xml:
+// <event><headers><Name>value</Name>...</headers><body>...</body></event>
+type xmlEvent struct {
+	Headers struct {
+		Items []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	} `xml:"headers"`
+	Body string `xml:"body"`
+}
+
+// decodeXMLEvent parses text/event-xml. Header values are URL-encoded just
+// like in plain events, and repeated headers are kept as an array.
+func decodeXMLEvent(frame *Event) (*Event, error) {
+	var tmp xmlEvent
+	if err := xml.Unmarshal([]byte(frame.Body), &tmp); err != nil {
+		return nil, err
+	}
+	return tmp.event(), nil
+}
+
+// event returns the Event x holds.
+func (x *xmlEvent) event() *Event {
+	ev := &Event{Header: make(map[string]string), Body: x.Body}
+	values := make(map[string][]string)
+	for _, item := range x.Headers.Items {
+		values[item.XMLName.Local] = append(values[item.XMLName.Local], urlDecode(item.Value))
+	}
+	for k, v := range values {
+		ev.Header[k] = joinValues(v)
+	}
+	return ev
+}
@@ -0,0 +1,187 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []header
+		err   string // "", "EOF" or "malformed"
+	}{
+		{
+			name:  "frame",
+			input: "Content-Type: command/reply\nReply-Text: +OK Job-UUID: 1234\n\n",
+			want:  []header{{"Content-Type", "command/reply"}, {"Reply-Text", "+OK Job-UUID: 1234"}},
+		},
+		{
+			name:  "stray blank lines",
+			input: "\n\r\n\nContent-Type: auth/request\n\n",
+			want:  []header{{"Content-Type", "auth/request"}},
+		},
+		{
+			name:  "case kept",
+			input: "variable_sip_from_user: bob\nUnique-ID: abc\n\n",
+			want:  []header{{"variable_sip_from_user", "bob"}, {"Unique-ID", "abc"}},
+		},
+		{
+			name:  "CRLF and spacing",
+			input: "Event-Name:  HEARTBEAT\r\nEmpty:\r\n\r\n",
+			want:  []header{{"Event-Name", "HEARTBEAT"}, {"Empty", ""}},
+		},
+		{
+			name:  "no colon",
+			input: "Content-Type: command/reply\nbogus line\n\n",
+			want:  []header{{"Content-Type", "command/reply"}},
+			err:   "malformed",
+		},
+		{
+			name:  "no name",
+			input: ": value\n\n",
+			err:   "malformed",
+		},
+		{
+			name:  "EOF in headers",
+			input: "Content-Type: api/response\nContent-Len",
+			want:  []header{{"Content-Type", "api/response"}},
+			err:   "malformed",
+		},
+		{
+			name:  "EOF after header line",
+			input: "Content-Type: api/response\n",
+			want:  []header{{"Content-Type", "api/response"}},
+			err:   "EOF",
+		},
+		{
+			name:  "EOF",
+			input: "",
+			err:   "EOF",
+		},
+	}
+	for _, tt := range tests {
+		got, err := readHeaders(bufio.NewReader(strings.NewReader(tt.input)))
+		var kind string
+		switch {
+		case err == io.EOF:
+			kind = "EOF"
+		case err != nil:
+			kind = "malformed"
+		}
+		if kind != tt.err {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadHeadersFrames(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("A: 1\n\n\nB: 2\n\n"))
+	for _, want := range []header{{"A", "1"}, {"B", "2"}} {
+		got, err := readHeaders(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if _, err := readHeaders(r); err != io.EOF {
+		t.Errorf("got %v after the last frame, want EOF", err)
+	}
+}
+
+func TestEncodedCommandReply(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	replies := make(chan *Event, 1)
+	go func() {
+		ev, err := e.ProtocolSendContext(context.Background(), "event", "plain ALL")
+		if err != nil {
+			t.Error(err)
+		}
+		replies <- ev
+	}()
+	srv.mustCommand(t)
+	io.WriteString(srv.conn, "Content-Type: command/reply\nReply-Text: %2BOK%20event%20listener%20enabled%20plain\nvariable_Mixed_Case: a%3Ab\n\n")
+	ev := <-replies
+	if got := ev.GetReplyText(); got != "+OK event listener enabled plain" {
+		t.Errorf("Reply-Text = %q", got)
+	}
+	if got, ok := ev.Header["variable_Mixed_Case"]; !ok || got != "a:b" {
+		t.Errorf("variable_Mixed_Case = %q, %t; headers %v", got, ok, ev.Header)
+	}
+}
+
+func TestDecodeJSONEvent(t *testing.T) {
+	body := `{"Event-Name":"CHANNEL_CREATE","variable_sip_h_X":["a","b c"],"Empty-Array":[],` +
+		`"Single":["only"],"Count":3,"Rate":1.5,"Flag":true,"Null":null,"Obj":{"k":"v"},` +
+		`"Mixed":["x",2,false],"_body":"hello"}`
+	ev, err := decodeJSONEvent(&Event{Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Event-Name":       "CHANNEL_CREATE",
+		"variable_sip_h_X": "ARRAY::a|:b c",
+		"Empty-Array":      "",
+		"Single":           "only",
+		"Count":            "3",
+		"Rate":             "1.5",
+		"Flag":             "true",
+		"Null":             "",
+		"Obj":              `{"k":"v"}`,
+		"Mixed":            "ARRAY::x|:2|:false",
+	}
+	if !reflect.DeepEqual(ev.Header, want) {
+		t.Errorf("got %v, want %v", ev.Header, want)
+	}
+	if ev.Body != "hello" {
+		t.Errorf("Body = %q", ev.Body)
+	}
+	if got := ev.GetValues("variable_sip_h_X"); !reflect.DeepEqual(got, []string{"a", "b c"}) {
+		t.Errorf("GetValues = %q", got)
+	}
+	if _, err := decodeJSONEvent(&Event{Body: "[1,2]"}); err == nil {
+		t.Error("decoding a JSON array as an event gave no error")
+	}
+}
+
+func TestDecodePlainRepeatedHeaders(t *testing.T) {
+	body := "Event-Name: CUSTOM\nvariable_sip_h_X: a%20b\nvariable_sip_h_X: c\nvariable_arr: ARRAY::1|:2\n\n"
+	ev, err := decodePlainEvent(&Event{Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ev.Header["variable_sip_h_X"]; got != "ARRAY::a b|:c" {
+		t.Errorf("repeated header = %q", got)
+	}
+	if got := ev.GetValues("variable_arr"); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Errorf("array header = %q", got)
+	}
+}
+
+func TestDecodeXMLRepeatedHeaders(t *testing.T) {
+	body := "<event><headers><Event-Name>CUSTOM</Event-Name><X>a%20b</X><X>c</X></headers></event>"
+	ev, err := decodeXMLEvent(&Event{Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ev.GetValues("X"); !reflect.DeepEqual(got, []string{"a b", "c"}) {
+		t.Errorf("repeated header = %q", got)
+	}
+}
@@ -0,0 +1,119 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+// DispatchMode selects how events read by Start are handed to the event
+// handlers. Every handler registered for the event name is called in all
+// modes.
+type DispatchMode int
+
+const (
+	// DispatchOrdered calls the handlers from one goroutine per channel:
+	// events of the same Unique-ID are handled one at a time, in the order
+	// they arrived, while different channels are handled in parallel.
+	// Events without a Unique-ID share a goroutine of their own.
+	DispatchOrdered DispatchMode = iota
+	// DispatchSync calls the handlers from the goroutine running Start,
+	// one event at a time. Handlers that block hold up every event, which
+	// queue up in memory meanwhile.
+	DispatchSync
+	// DispatchConcurrent calls each handler in a goroutine of its own,
+	// with no ordering at all.
+	DispatchConcurrent
+)
+
+func (m DispatchMode) String() string {
+	switch m {
+	case DispatchOrdered:
+		return "ordered"
+	case DispatchSync:
+		return "sync"
+	case DispatchConcurrent:
+		return "concurrent"
+	}
+	return "unknown"
+}
+
+// SetDispatchMode sets how events are handed to the handlers. It applies
+// to the events dispatched from then on.
+func (e *EventSocket) SetDispatchMode(mode DispatchMode) {
+	e.mtx.Lock()
+	e.dispatch = mode
+	e.mtx.Unlock()
+}
+
+// dispatchEvent hands event to every handler registered for its name, for
+// CUSTOM events its subclass, and for ALL, according to the dispatch mode.
+func (e *EventSocket) dispatchEvent(event *Event) {
+	eventName := event.GetHeader("Event-Name", "")
+	if eventName == "" {
+		return
+	}
+	e.mtx.Lock()
+	handlers := e.handlersFor(eventName, event.GetHeader("Event-Subclass", ""))
+	mode := e.dispatch
+	e.mtx.Unlock()
+	if len(handlers) == 0 {
+		return
+	}
+	run := func() {
+		for _, h := range handlers {
+			h.fn(event)
+		}
+	}
+	switch mode {
+	case DispatchSync:
+		run()
+	case DispatchConcurrent:
+		for _, h := range handlers {
+			go h.fn(event)
+		}
+	default:
+		e.enqueue(channelUUID(event), run)
+	}
+}
+
+// handlersFor returns the handlers of an event: those registered for its
+// name, for CUSTOM events its subclass, and for ALL. e.mtx must be held.
+func (e *EventSocket) handlersFor(eventName, subclass string) []*eventHandler {
+	handlers := e.eventHandlers[eventName]
+	if eventName == "CUSTOM" && subclass != "" {
+		custom := e.eventHandlers[eventKey(eventName, subclass)]
+		handlers = append(handlers[:len(handlers):len(handlers)], custom...)
+	}
+	return append(handlers[:len(handlers):len(handlers)], e.eventHandlers["ALL"]...)
+}
+
+// enqueue queues fn to be run after the functions queued before it with
+// the same key, starting a worker for key if none is running.
+func (e *EventSocket) enqueue(key string, fn func()) {
+	e.mtx.Lock()
+	queue, running := e.queues[key]
+	e.queues[key] = append(queue, fn)
+	e.mtx.Unlock()
+	if !running {
+		go e.drain(key)
+	}
+}
+
+// drain runs the functions queued for key until the queue is empty.
+func (e *EventSocket) drain(key string) {
+	for {
+		e.mtx.Lock()
+		queue := e.queues[key]
+		if len(queue) == 0 {
+			delete(e.queues, key)
+			e.mtx.Unlock()
+			return
+		}
+		fn := queue[0]
+		queue[0] = nil
+		e.queues[key] = queue[1:]
+		e.mtx.Unlock()
+		fn()
+	}
+}
@@ -0,0 +1,103 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dispatchRecorder records the events each handler was called with.
+type dispatchRecorder struct {
+	mtx  sync.Mutex
+	wg   sync.WaitGroup
+	seen map[string][]int // By handler and Unique-ID
+}
+
+func (r *dispatchRecorder) handler(name string) func(*Event) {
+	return func(ev *Event) {
+		defer r.wg.Done()
+		seq, _ := strconv.Atoi(ev.GetHeader("Event-Sequence", ""))
+		// Give events of the same channel a chance to overtake each other.
+		time.Sleep(time.Duration(seq%3) * time.Millisecond)
+		r.mtx.Lock()
+		key := name + " " + channelUUID(ev)
+		r.seen[key] = append(r.seen[key], seq)
+		r.mtx.Unlock()
+	}
+}
+
+// dispatchAll dispatches n events per channel, alternating between the
+// channels a and b, to three handlers and waits until they all ran.
+func dispatchAll(t *testing.T, mode DispatchMode, n int) map[string][]int {
+	e, _ := newTestSocket(t)
+	e.SetDispatchMode(mode)
+	r := &dispatchRecorder{seen: make(map[string][]int)}
+	e.eventHandlers["CHANNEL_STATE"] = []*eventHandler{{fn: r.handler("first")}, {fn: r.handler("second")}}
+	e.eventHandlers["ALL"] = []*eventHandler{{fn: r.handler("all")}}
+	r.wg.Add(3 * 2 * n)
+	for i := 0; i < n; i++ {
+		for _, uuid := range []string{"a", "b"} {
+			e.dispatchEvent(&Event{Header: map[string]string{
+				"Event-Name":     "CHANNEL_STATE",
+				"Unique-ID":      uuid,
+				"Event-Sequence": strconv.Itoa(i),
+			}})
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not every handler was called")
+	}
+	return r.seen
+}
+
+func TestDispatchOrdered(t *testing.T) {
+	const n = 20
+	seen := dispatchAll(t, DispatchOrdered, n)
+	for _, name := range []string{"first", "second", "all"} {
+		for _, uuid := range []string{"a", "b"} {
+			key := name + " " + uuid
+			if len(seen[key]) != n {
+				t.Fatalf("%s got %d events, want %d", key, len(seen[key]), n)
+			}
+			for i, seq := range seen[key] {
+				if seq != i {
+					t.Fatalf("%s got events out of order: %v", key, seen[key])
+				}
+			}
+		}
+	}
+}
+
+func TestDispatchSyncAndConcurrent(t *testing.T) {
+	const n = 10
+	for _, mode := range []DispatchMode{DispatchSync, DispatchConcurrent} {
+		seen := dispatchAll(t, mode, n)
+		for _, name := range []string{"first", "second", "all"} {
+			for _, uuid := range []string{"a", "b"} {
+				if key := name + " " + uuid; len(seen[key]) != n {
+					t.Errorf("%s: %s got %d events, want %d", mode, key, len(seen[key]), n)
+				}
+			}
+		}
+		if mode == DispatchSync {
+			if got, want := fmt.Sprint(seen["first a"]), fmt.Sprint(seen["all a"]); got != want {
+				t.Errorf("sync: handlers saw %s and %s", got, want)
+			}
+		}
+	}
+}
@@ -8,12 +8,14 @@ Provides FreeSWITCH socket communication.
 package fsswitch
 
 import (
+	"errors"
 	"fmt"
 	_ "log"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type EventHeader map[string]string
@@ -48,24 +50,215 @@ func (self *Event) PrettyPrint() {
 }
 
 // Get returns an Event value, or "" if the key doesn't exist.
+// Keys are matched regardless of case, like LookupHeader does.
 func (self *Event) GetHeader(key, defaultValue string) string {
-	if hdr := self.Header[key]; hdr != "" {
+	if hdr, _ := self.LookupHeader(key); hdr != "" {
 		//log.Printf("Header:%s, Val:%s", key, hdr)
 		return hdr
 	}
 	return defaultValue
 }
 
+// LookupHeader returns the value of the header key and whether it is set.
+// Headers keep the case FreeSWITCH sent them in, in plain, JSON and XML
+// events alike; a key in another case is still found, so "Unique-Id"
+// finds Unique-ID.
+func (self *Event) LookupHeader(key string) (string, bool) {
+	if v, ok := self.Header[key]; ok {
+		return v, true
+	}
+	for k, v := range self.Header {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// GetValues returns every value of the header key, or nil if it is not
+// set. Array headers, sent by FreeSWITCH as "ARRAY::a|:b|:c" (or as arrays
+// in JSON events), give one value per element; other headers give their
+// single value. FreeSWITCH does not escape the "|:" separator, so an
+// element containing it comes back split in two.
+func (self *Event) GetValues(key string) []string {
+	v, ok := self.LookupHeader(key)
+	if !ok {
+		return nil
+	}
+	return splitValues(v)
+}
+
+// arrayPrefix starts the values of array headers, whose elements are
+// separated by "|:". There is no escaping: an element containing "|:" or
+// a single value starting with ARRAY:: can't be told apart from an array.
+const arrayPrefix = "ARRAY::"
+
+// joinValues encodes the values of a header the way FreeSWITCH encodes
+// array headers, unless there is a single value.
+func joinValues(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return arrayPrefix + strings.Join(values, "|:")
+}
+
+// splitValues decodes a header value encoded by joinValues.
+func splitValues(v string) []string {
+	if !strings.HasPrefix(v, arrayPrefix) {
+		return []string{v}
+	}
+	return strings.Split(v[len(arrayPrefix):], "|:")
+}
+
 // GetInt returns an Event value converted to int, or an error if conversion
 // is not possible.
 func (self *Event) GetInt(key string) (int, error) {
-	n, err := strconv.Atoi(self.Header[key])
+	v, _ := self.LookupHeader(key)
+	n, err := strconv.Atoi(v)
 	if err != nil {
 		return 0, err
 	}
 	return n, nil
 }
 
+// HeaderError is returned when a header is not set or its value doesn't
+// parse as the type asked for.
+type HeaderError struct {
+	Header string
+	Value  string
+	Err    error
+}
+
+func (h *HeaderError) Error() string {
+	if h.Err == errHeaderNotSet {
+		return fmt.Sprintf("Header %s not set", h.Header)
+	}
+	return fmt.Sprintf("Invalid header %s: %q: %s", h.Header, h.Value, h.Err)
+}
+
+func (h *HeaderError) Unwrap() error { return h.Err }
+
+var errHeaderNotSet = errors.New("Header not set")
+
+// value returns the value of the header key, or a *HeaderError if it is
+// missing or empty.
+func (self *Event) value(key string) (string, error) {
+	if v, _ := self.LookupHeader(key); v != "" {
+		return v, nil
+	}
+	return "", &HeaderError{Header: key, Err: errHeaderNotSet}
+}
+
+// Variables returns the channel variables carried by the event: the
+// variable_* headers, keyed by name without the prefix.
+func (self *Event) Variables() map[string]string {
+	vars := make(map[string]string)
+	for k, v := range self.Header {
+		if len(k) > len(varPrefix) && strings.EqualFold(k[:len(varPrefix)], varPrefix) {
+			vars[k[len(varPrefix):]] = v
+		}
+	}
+	return vars
+}
+
+const varPrefix = "variable_"
+
+// GetVar returns the channel variable name, i.e. the variable_<name>
+// header, and whether it is set.
+func (self *Event) GetVar(name string) (string, bool) {
+	return self.LookupHeader(varPrefix + name)
+}
+
+// GetInt64 returns an Event value converted to int64.
+func (self *Event) GetInt64(key string) (int64, error) {
+	v, err := self.value(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, &HeaderError{Header: key, Value: v, Err: err}
+	}
+	return n, nil
+}
+
+// GetFloat returns an Event value converted to float64.
+func (self *Event) GetFloat(key string) (float64, error) {
+	v, err := self.value(key)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, &HeaderError{Header: key, Value: v, Err: err}
+	}
+	return f, nil
+}
+
+// GetBool returns an Event value converted to bool. Like FreeSWITCH it
+// takes true, yes, on, enabled, active, allow and non-zero numbers as
+// true, and false, no, off, disabled, inactive, deny and 0 as false.
+func (self *Event) GetBool(key string) (bool, error) {
+	v, err := self.value(key)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(v) {
+	case "true", "t", "yes", "y", "on", "enabled", "active", "allow":
+		return true, nil
+	case "false", "f", "no", "n", "off", "disabled", "inactive", "deny":
+		return false, nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n != 0, nil
+	}
+	return false, &HeaderError{Header: key, Value: v, Err: strconv.ErrSyntax}
+}
+
+// GetDuration returns an Event value holding an integer count of unit as a
+// Duration, e.g. GetDuration("variable_billsec", time.Second) or
+// GetDuration("variable_billmsec", time.Millisecond).
+func (self *Event) GetDuration(key string, unit time.Duration) (time.Duration, error) {
+	n, err := self.GetInt64(key)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// GetTime returns an Event value holding a time. It takes the epoch
+// timestamps FreeSWITCH uses, in microseconds (Event-Date-Timestamp,
+// Caller-Channel-*-Time, variable_*_uepoch) or seconds (variable_*_epoch),
+// told apart by their magnitude, as well as Event-Date-GMT and the local
+// times of Event-Date-Local and variable_*_stamp. A timestamp of 0, sent
+// for things that haven't happened (e.g. the answer time of a channel
+// that was never answered), gives the zero Time.
+func (self *Event) GetTime(key string) (time.Time, error) {
+	v, err := self.value(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		switch {
+		case n == 0:
+			return time.Time{}, nil
+		case n >= 1e14:
+			return time.Unix(0, n*int64(time.Microsecond)), nil
+		case n >= 1e11:
+			return time.Unix(0, n*int64(time.Millisecond)), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", v, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC1123, v)
+	if err != nil {
+		return time.Time{}, &HeaderError{Header: key, Value: v, Err: err}
+	}
+	return t, nil
+}
+
 func (self *Event) GetContentLength() int {
 	//Gets Content-Length header as integer.  Returns 0 If length not found.
 	conLen := self.GetHeader("Content-Length", "0")
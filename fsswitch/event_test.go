@@ -0,0 +1,57 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJoinSplitValues(t *testing.T) {
+	tests := []struct {
+		values []string
+		joined string
+	}{
+		{[]string{"a"}, "a"},
+		{[]string{""}, ""},
+		{[]string{"a", "b"}, "ARRAY::a|:b"},
+		{[]string{"a", "", "c d"}, "ARRAY::a|:|:c d"},
+		{[]string{"x|y", "z:1"}, "ARRAY::x|y|:z:1"},
+	}
+	for _, tt := range tests {
+		if got := joinValues(tt.values); got != tt.joined {
+			t.Errorf("joinValues(%q) = %q, want %q", tt.values, got, tt.joined)
+		}
+		if got := splitValues(tt.joined); !reflect.DeepEqual(got, tt.values) {
+			t.Errorf("splitValues(%q) = %q, want %q", tt.joined, got, tt.values)
+		}
+	}
+}
+
+func TestJoinValuesSeparatorNotEscaped(t *testing.T) {
+	// FreeSWITCH doesn't escape the separator either; see GetValues.
+	got := splitValues(joinValues([]string{"a|:b", "c"}))
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGetValues(t *testing.T) {
+	ev := &Event{Header: map[string]string{
+		"variable_sip_h_X": "ARRAY::1|:2",
+		"Event-Name":       "CHANNEL_CREATE",
+	}}
+	if got := ev.GetValues("variable_sip_h_x"); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Errorf("array header: got %q", got)
+	}
+	if got := ev.GetValues("Event-Name"); !reflect.DeepEqual(got, []string{"CHANNEL_CREATE"}) {
+		t.Errorf("single header: got %q", got)
+	}
+	if got := ev.GetValues("Missing"); got != nil {
+		t.Errorf("missing header: got %q", got)
+	}
+}
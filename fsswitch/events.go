@@ -0,0 +1,324 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// headerDecoder reads typed header values with the Event getters,
+// remembering the first error. Missing headers decode to the zero value.
+type headerDecoder struct {
+	ev  *Event
+	err error
+}
+
+func (d *headerDecoder) check(err error) {
+	if err != nil && !errors.Is(err, errHeaderNotSet) && d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *headerDecoder) str(name string) string {
+	v, _ := d.ev.LookupHeader(name)
+	return v
+}
+
+func (d *headerDecoder) int(name string) int {
+	n, err := d.ev.GetInt64(name)
+	d.check(err)
+	return int(n)
+}
+
+func (d *headerDecoder) int64(name string) int64 {
+	n, err := d.ev.GetInt64(name)
+	d.check(err)
+	return n
+}
+
+func (d *headerDecoder) float(name string) float64 {
+	f, err := d.ev.GetFloat(name)
+	d.check(err)
+	return f
+}
+
+func (d *headerDecoder) bool(name string) bool {
+	b, err := d.ev.GetBool(name)
+	d.check(err)
+	return b
+}
+
+func (d *headerDecoder) time(name string) time.Time {
+	t, err := d.ev.GetTime(name)
+	d.check(err)
+	return t
+}
+
+func (d *headerDecoder) duration(name string, unit time.Duration) time.Duration {
+	dur, err := d.ev.GetDuration(name, unit)
+	d.check(err)
+	return dur
+}
+
+// HangupCause is the Hangup-Cause of a channel, e.g. NORMAL_CLEARING.
+type HangupCause string
+
+const (
+	HangupNone                  HangupCause = "NONE"
+	HangupUnallocatedNumber     HangupCause = "UNALLOCATED_NUMBER"
+	HangupNoRouteDestination    HangupCause = "NO_ROUTE_DESTINATION"
+	HangupNormalClearing        HangupCause = "NORMAL_CLEARING"
+	HangupUserBusy              HangupCause = "USER_BUSY"
+	HangupNoUserResponse        HangupCause = "NO_USER_RESPONSE"
+	HangupNoAnswer              HangupCause = "NO_ANSWER"
+	HangupCallRejected          HangupCause = "CALL_REJECTED"
+	HangupNumberChanged         HangupCause = "NUMBER_CHANGED"
+	HangupDestinationOutOfOrder HangupCause = "DESTINATION_OUT_OF_ORDER"
+	HangupInvalidNumberFormat   HangupCause = "INVALID_NUMBER_FORMAT"
+	HangupNormalUnspecified     HangupCause = "NORMAL_UNSPECIFIED"
+	HangupNormalCircuitCongest  HangupCause = "NORMAL_CIRCUIT_CONGESTION"
+	HangupNetworkOutOfOrder     HangupCause = "NETWORK_OUT_OF_ORDER"
+	HangupNormalTemporaryFail   HangupCause = "NORMAL_TEMPORARY_FAILURE"
+	HangupSwitchCongestion      HangupCause = "SWITCH_CONGESTION"
+	HangupIncompatibleDest      HangupCause = "INCOMPATIBLE_DESTINATION"
+	HangupRecoveryOnTimerExpire HangupCause = "RECOVERY_ON_TIMER_EXPIRE"
+	HangupOriginatorCancel      HangupCause = "ORIGINATOR_CANCEL"
+	HangupAllottedTimeout       HangupCause = "ALLOTTED_TIMEOUT"
+	HangupLoseRace              HangupCause = "LOSE_RACE"
+	HangupManagerRequest        HangupCause = "MANAGER_REQUEST"
+	HangupBlindTransfer         HangupCause = "BLIND_TRANSFER"
+	HangupAttendedTransfer      HangupCause = "ATTENDED_TRANSFER"
+	HangupPickedOff             HangupCause = "PICKED_OFF"
+)
+
+// q850 maps the causes that have one to their Q.850 cause code.
+var q850 = map[HangupCause]int{
+	HangupUnallocatedNumber:     1,
+	HangupNoRouteDestination:    3,
+	HangupNormalClearing:        16,
+	HangupUserBusy:              17,
+	HangupNoUserResponse:        18,
+	HangupNoAnswer:              19,
+	HangupCallRejected:          21,
+	HangupNumberChanged:         22,
+	HangupDestinationOutOfOrder: 27,
+	HangupInvalidNumberFormat:   28,
+	HangupNormalUnspecified:     31,
+	HangupNormalCircuitCongest:  34,
+	HangupNetworkOutOfOrder:     38,
+	HangupNormalTemporaryFail:   41,
+	HangupSwitchCongestion:      42,
+	HangupIncompatibleDest:      88,
+	HangupRecoveryOnTimerExpire: 102,
+}
+
+// Q850 returns the Q.850 cause code of c, or 0 for causes internal to
+// FreeSWITCH such as ORIGINATOR_CANCEL.
+func (c HangupCause) Q850() int {
+	return q850[c]
+}
+
+// Normal reports whether c ends a call that went as expected.
+func (c HangupCause) Normal() bool {
+	return c == HangupNormalClearing || c == HangupNormalUnspecified
+}
+
+// ChannelEvent holds the headers common to the CHANNEL_* events.
+type ChannelEvent struct {
+	Name              string    // Event-Name
+	Timestamp         time.Time // Event-Date-Timestamp
+	Sequence          int64     // Event-Sequence
+	UUID              string    // Unique-ID
+	Direction         string    // Call-Direction: inbound or outbound
+	State             string    // Channel-State, e.g. CS_EXECUTE
+	StateNumber       int       // Channel-State-Number
+	CallState         string    // Channel-Call-State, e.g. ACTIVE
+	AnswerState       string    // Answer-State, e.g. answered
+	CallerIDName      string    // Caller-Caller-ID-Name
+	CallerIDNumber    string    // Caller-Caller-ID-Number
+	DestinationNumber string    // Caller-Destination-Number
+	OtherLegUUID      string    // Other-Leg-Unique-ID
+	HitDialplan       bool      // Channel-HIT-Dialplan
+	CreatedTime       time.Time // Caller-Channel-Created-Time
+	AnsweredTime      time.Time // Caller-Channel-Answered-Time
+	HangupTime        time.Time // Caller-Channel-Hangup-Time
+	Event             *Event    // The event decoded
+}
+
+func (c *ChannelEvent) decode(d *headerDecoder) {
+	c.Name = d.str("Event-Name")
+	c.Timestamp = d.time("Event-Date-Timestamp")
+	c.Sequence = d.int64("Event-Sequence")
+	c.UUID = d.str("Unique-ID")
+	c.Direction = d.str("Call-Direction")
+	c.State = d.str("Channel-State")
+	c.StateNumber = d.int("Channel-State-Number")
+	c.CallState = d.str("Channel-Call-State")
+	c.AnswerState = d.str("Answer-State")
+	c.CallerIDName = d.str("Caller-Caller-ID-Name")
+	c.CallerIDNumber = d.str("Caller-Caller-ID-Number")
+	c.DestinationNumber = d.str("Caller-Destination-Number")
+	c.OtherLegUUID = d.str("Other-Leg-Unique-ID")
+	c.HitDialplan = d.bool("Channel-HIT-Dialplan")
+	c.CreatedTime = d.time("Caller-Channel-Created-Time")
+	c.AnsweredTime = d.time("Caller-Channel-Answered-Time")
+	c.HangupTime = d.time("Caller-Channel-Hangup-Time")
+	c.Event = d.ev
+}
+
+// DecodeChannelEvent decodes the channel headers of ev.
+func DecodeChannelEvent(ev *Event) (*ChannelEvent, error) {
+	d := &headerDecoder{ev: ev}
+	c := new(ChannelEvent)
+	c.decode(d)
+	return c, d.err
+}
+
+// HangupEvent is a CHANNEL_HANGUP or CHANNEL_HANGUP_COMPLETE event.
+type HangupEvent struct {
+	ChannelEvent
+	Cause    HangupCause   // Hangup-Cause
+	Q850     int           // variable_hangup_cause_q850, else derived from Cause
+	Duration time.Duration // variable_duration, whole seconds
+	BillSec  time.Duration // variable_billsec, whole seconds
+}
+
+// DecodeHangupEvent decodes a CHANNEL_HANGUP or CHANNEL_HANGUP_COMPLETE
+// event.
+func DecodeHangupEvent(ev *Event) (*HangupEvent, error) {
+	d := &headerDecoder{ev: ev}
+	h := new(HangupEvent)
+	h.decode(d)
+	h.Cause = HangupCause(d.str("Hangup-Cause"))
+	if h.Q850 = d.int("variable_hangup_cause_q850"); h.Q850 == 0 {
+		h.Q850 = h.Cause.Q850()
+	}
+	h.Duration = d.duration("variable_duration", time.Second)
+	h.BillSec = d.duration("variable_billsec", time.Second)
+	return h, d.err
+}
+
+// DTMFEvent is a DTMF event.
+type DTMFEvent struct {
+	ChannelEvent
+	Digit    string // DTMF-Digit
+	Duration int    // DTMF-Duration, in samples
+	Source   string // DTMF-Source, e.g. RTP or INBAND_AUDIO
+}
+
+// DecodeDTMFEvent decodes a DTMF event.
+func DecodeDTMFEvent(ev *Event) (*DTMFEvent, error) {
+	d := &headerDecoder{ev: ev}
+	e := new(DTMFEvent)
+	e.decode(d)
+	e.Digit = d.str("DTMF-Digit")
+	e.Duration = d.int("DTMF-Duration")
+	e.Source = d.str("DTMF-Source")
+	return e, d.err
+}
+
+// ExecuteCompleteEvent is a CHANNEL_EXECUTE or CHANNEL_EXECUTE_COMPLETE
+// event.
+type ExecuteCompleteEvent struct {
+	ChannelEvent
+	Application         string // Application
+	ApplicationData     string // Application-Data
+	ApplicationResponse string // Application-Response
+	ApplicationUUID     string // Application-UUID, the Event-UUID of the sendmsg
+}
+
+// DecodeExecuteCompleteEvent decodes a CHANNEL_EXECUTE or
+// CHANNEL_EXECUTE_COMPLETE event.
+func DecodeExecuteCompleteEvent(ev *Event) (*ExecuteCompleteEvent, error) {
+	d := &headerDecoder{ev: ev}
+	e := new(ExecuteCompleteEvent)
+	e.decode(d)
+	e.Application = d.str("Application")
+	e.ApplicationData = d.str("Application-Data")
+	e.ApplicationResponse = d.str("Application-Response")
+	e.ApplicationUUID = d.str("Application-UUID")
+	return e, d.err
+}
+
+// BackgroundJobEvent is the BACKGROUND_JOB event carrying the result of a
+// bgapi command.
+type BackgroundJobEvent struct {
+	Timestamp time.Time // Event-Date-Timestamp
+	JobUUID   string    // Job-UUID
+	Command   string    // Job-Command
+	Args      string    // Job-Command-Arg
+	Result    string    // The body, usually starting with +OK or -ERR
+	Event     *Event    // The event decoded
+}
+
+// DecodeBackgroundJobEvent decodes a BACKGROUND_JOB event.
+func DecodeBackgroundJobEvent(ev *Event) (*BackgroundJobEvent, error) {
+	d := &headerDecoder{ev: ev}
+	return &BackgroundJobEvent{
+		Timestamp: d.time("Event-Date-Timestamp"),
+		JobUUID:   d.str("Job-UUID"),
+		Command:   d.str("Job-Command"),
+		Args:      d.str("Job-Command-Arg"),
+		Result:    strings.TrimSpace(ev.Body),
+		Event:     ev,
+	}, d.err
+}
+
+// HeartbeatEvent is the HEARTBEAT event FreeSWITCH sends every 20 seconds.
+type HeartbeatEvent struct {
+	Timestamp            time.Time     // Event-Date-Timestamp
+	Hostname             string        // FreeSWITCH-Hostname
+	Version              string        // FreeSWITCH-Version
+	Uptime               time.Duration // Uptime-msec
+	SessionCount         int           // Session-Count
+	MaxSessions          int           // Max-Sessions
+	SessionsSinceStartup int64         // Session-Since-Startup
+	SessionsPerSec       int           // Session-Per-Sec
+	IdleCPU              float64       // Idle-CPU, in percent
+	Event                *Event        // The event decoded
+}
+
+// DecodeHeartbeatEvent decodes a HEARTBEAT event.
+func DecodeHeartbeatEvent(ev *Event) (*HeartbeatEvent, error) {
+	d := &headerDecoder{ev: ev}
+	return &HeartbeatEvent{
+		Timestamp:            d.time("Event-Date-Timestamp"),
+		Hostname:             d.str("FreeSWITCH-Hostname"),
+		Version:              d.str("FreeSWITCH-Version"),
+		Uptime:               d.duration("Uptime-msec", time.Millisecond),
+		SessionCount:         d.int("Session-Count"),
+		MaxSessions:          d.int("Max-Sessions"),
+		SessionsSinceStartup: d.int64("Session-Since-Startup"),
+		SessionsPerSec:       d.int("Session-Per-Sec"),
+		IdleCPU:              d.float("Idle-CPU"),
+		Event:                ev,
+	}, d.err
+}
+
+// DecodeEvent decodes ev into the typed event for its Event-Name: a
+// *HangupEvent, *DTMFEvent, *ExecuteCompleteEvent, *BackgroundJobEvent,
+// *HeartbeatEvent, or a *ChannelEvent for the other CHANNEL_* events.
+// Other events are returned as is.
+func DecodeEvent(ev *Event) (interface{}, error) {
+	switch name := ev.GetHeader("Event-Name", ""); {
+	case name == "CHANNEL_HANGUP" || name == "CHANNEL_HANGUP_COMPLETE":
+		return DecodeHangupEvent(ev)
+	case name == "DTMF":
+		return DecodeDTMFEvent(ev)
+	case name == "CHANNEL_EXECUTE" || name == "CHANNEL_EXECUTE_COMPLETE":
+		return DecodeExecuteCompleteEvent(ev)
+	case name == "BACKGROUND_JOB":
+		return DecodeBackgroundJobEvent(ev)
+	case name == "HEARTBEAT":
+		return DecodeHeartbeatEvent(ev)
+	case strings.HasPrefix(name, "CHANNEL_"):
+		return DecodeChannelEvent(ev)
+	}
+	return ev, nil
+}
@@ -7,77 +7,248 @@ package fsswitch
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"net/textproto"
 	"net/url"
-	_ "sort"
+	"sort"
 	"strconv"
-	_ "strings"
+	"strings"
+	"sync"
+	"time"
 )
 
-const eventsBuffer = 16      // For the events channel (memory eater!)
+const eventsBuffer = 16      // For the log lines channel (memory eater!)
 const bufferSize = 1024 << 6 // For the socket reader
+
+var errNotConnected = errors.New("Not connected to FS")
+var errDisconnected = errors.New("Disconnected")
+var errJobPending = errors.New("Job has not completed")
+var errSubscribeFailed = errors.New("Event subscription failed")
+var errNoEventName = errors.New("Event has no Event-Name or Event-Subclass")
+var errLineBreak = errors.New("Line break in header")
+
+// TimeoutError is returned by the ...Context methods when the deadline of
+// the context passes before FreeSWITCH replies to Command.
+type TimeoutError struct {
+	Command string
+	Err     error
+}
+
+func (t *TimeoutError) Error() string {
+	return fmt.Sprintf("%s: timed out waiting for reply: %v", t.Command, t.Err)
+}
+
+// Timeout reports true, so TimeoutError satisfies net.Error style checks.
+func (t *TimeoutError) Timeout() bool { return true }
+
+func (t *TimeoutError) Unwrap() error { return t.Err }
+
+// AuthError is returned by Auth and UserAuth when FreeSWITCH rejects the
+// credentials.
+type AuthError struct {
+	User  string // user@domain for userauth, empty for auth
+	Reply string // Reply-Text, usually "-ERR invalid"
+}
+
+func (a *AuthError) Error() string {
+	if a.User != "" {
+		return fmt.Sprintf("Authentication of %s failed: %s", a.User, a.Reply)
+	}
+	return fmt.Sprintf("Authentication failed: %s", a.Reply)
+}
+
+// PermissionError is returned, along with the reply, when FreeSWITCH
+// refuses a command because of the esl-allowed-api or esl-allowed-events
+// restrictions of the user authenticated with userauth.
+type PermissionError struct {
+	Command string
+	Reply   string
+}
+
+func (p *PermissionError) Error() string {
+	return fmt.Sprintf("%s: %s", p.Command, p.Reply)
+}
+
+// permissionDenied returns the denial text if ev refuses a command for
+// lack of permission.
+func permissionDenied(ev *Event) (string, bool) {
+	const denied = "-ERR permission denied"
+	if reply := ev.GetReplyText(); strings.HasPrefix(reply, denied) {
+		return reply, true
+	}
+	if ev.GetContentType() == "api/response" && strings.HasPrefix(ev.Body, denied) {
+		return strings.TrimSpace(ev.Body), true
+	}
+	return "", false
+}
+
+// contextError maps a context error to the error returned to the caller.
+func contextError(command string, err error) error {
+	if err == context.DeadlineExceeded {
+		return &TimeoutError{Command: command, Err: err}
+	}
+	return err
+}
+
+// Logger is where sockets write their diagnostics. *log.Logger satisfies
+// it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdLogger writes to the standard logger of package log.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// request is a fully framed command queued for the writer goroutine. Its reply is
+// delivered on a buffered channel so that a caller that gave up waiting
+// never blocks the reader.
+type request struct {
+	ctx   context.Context
+	cmd   string
+	reply chan reply
+}
+
+type reply struct {
+	ev  *Event
+	err error
+}
+
 type EventSocket struct {
-	conn                        net.Conn
-	buffer                      *bufio.Reader
-	reader                      *bufio.Reader
-	textreader                  *textproto.Reader
-	eventHandlers               map[string][]func(*Event)
-	err                         chan error
-	auth, discon, cmd, api, evt chan *Event
+	conn          net.Conn
+	buffer        *bufio.Reader
+	reader        *bufio.Reader
+	eventHandlers map[string][]*eventHandler   // Protected by mtx
+	handlerMtx    sync.Mutex                   // Serializes On and Off
+	handlerEvents map[string]bool              // Events subscribed to for handlers
+	subscribing   map[string]*pendingSubscribe // Handler subscribes in progress, protected by mtx
+	err           chan error
+	auth, discon  chan *Event
+	evt           chan struct{} // Signals that events were queued
+	requests      chan *request // Commands waiting to be written
+	replied       chan struct{} // Signals the writer that a reply came in
+	mtx           sync.Mutex    // Protects the fields below
+	pending       []*request    // Written commands awaiting replies, in wire order
+	queued        []*Event      // Events waiting for readEvent
+	depth         int           // Max commands in flight
+	format        string        // Event format of the last event command
+	events        map[string]bool
+	filters       []EventFilter   // Active filters
+	divert        string          // Last divert_events flag
+	myevents      []string        // UUIDs passed to myevents
+	jobs          map[string]*Job // Background jobs by Job-UUID
+	waitExecute   bool
+	executes      map[string]chan *Event  // Execute waiters by Application-UUID
+	decoders      map[string]FrameDecoder // Event decoders by Content-Type
+	unknownFrame  func(*Event, error)
+	logs          chan *LogLine
+	logHandlers   []func(*LogLine)
+	logger        Logger
+	observers     []func(*Event)
+	dispatch      DispatchMode
+	queues        map[string][]func() // Per channel handler queues
+	closed        chan struct{}       // Closed when the read loop exits
 }
 
 func NewEventSocket(c net.Conn, evntHandlers map[string][]func(*Event)) *EventSocket {
 	socks := EventSocket{
-		conn:          c,
-		reader:        bufio.NewReaderSize(c, bufferSize),
-		eventHandlers: evntHandlers,
-		err:           make(chan error),
-		cmd:           make(chan *Event),
-		api:           make(chan *Event),
+		eventHandlers: make(map[string][]*eventHandler),
+		handlerEvents: make(map[string]bool),
+		subscribing:   make(map[string]*pendingSubscribe),
+		err:           make(chan error, 1),
 		auth:          make(chan *Event),
 		discon:        make(chan *Event),
-		evt:           make(chan *Event, eventsBuffer),
+		evt:           make(chan struct{}, 1),
+		requests:      make(chan *request),
+		replied:       make(chan struct{}, 1),
+		depth:         1,
+		format:        "plain",
+		events:        make(map[string]bool),
+		jobs:          make(map[string]*Job),
+		executes:      make(map[string]chan *Event),
+		decoders:      defaultDecoders(),
+		logs:          make(chan *LogLine, eventsBuffer),
+		logger:        stdLogger{},
+		queues:        make(map[string][]func()),
+	}
+	for key, fns := range evntHandlers {
+		key = eventKey(splitEventKey(key))
+		socks.handlerEvents[key] = true
+		for _, fn := range fns {
+			socks.eventHandlers[key] = append(socks.eventHandlers[key], &eventHandler{fn: fn})
+		}
 	}
-	socks.textreader = textproto.NewReader(socks.reader)
+	socks.attach(c)
 	return &socks
 }
 
-// It's used after parsing plain text event headers, but not JSON.
-func copyHeaders(src *textproto.MIMEHeader, dst *Event, decode bool) {
-	var err error
-	for k, v := range *src {
-		//k = capitalize(k)
+// attach makes c the connection of the socket. It is also used to swap in a
+// new connection after the previous read loop has exited; handlers,
+// settings and the recorded subscriptions are kept.
+func (e *EventSocket) attach(c net.Conn) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.conn = c
+	e.reader = bufio.NewReaderSize(c, bufferSize)
+	e.closed = make(chan struct{})
+	// Drop the error that ended the previous connection, if nobody read it.
+	select {
+	case <-e.err:
+	default:
+	}
+}
+
+// SetLogger makes the socket write its diagnostics to logger instead of the
+// standard logger. Call it before the read loop is started.
+func (e *EventSocket) SetLogger(logger Logger) {
+	e.logger = logger
+}
+
+// done returns a channel closed when the current connection is gone.
+func (e *EventSocket) done() <-chan struct{} {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.closed
+}
+
+// It's used after parsing plain text event headers, but not JSON. Names
+// keep the case they were sent in; repeated headers are kept as an array.
+func copyHeaders(src []header, dst *Event, decode bool) {
+	values := make(map[string][]string, len(src))
+	for _, h := range src {
+		k, v := h.name, h.value
 		if decode {
-			dst.Header[k], err = url.QueryUnescape(v[0])
-			if err != nil {
-				dst.Header[k] = v[0]
+			if unescaped, err := url.QueryUnescape(v); err == nil {
+				v = unescaped
 			}
-		} else {
-			dst.Header[k] = v[0]
 		}
+		values[k] = append(values[k], v)
+	}
+	for k, v := range values {
+		dst.Header[k] = joinValues(v)
 	}
 }
 
 // readOne reads a single event and send over the appropriate channel.
 // It separates incoming events from api and command responses.
 func (e *EventSocket) readOne() bool {
-	log.Println("readOne Start")
-	hdr, err := e.textreader.ReadMIMEHeader()
+	e.logger.Printf("readOne Start")
+	hdr, err := readHeaders(e.reader)
 	if err != nil {
 		e.err <- err
-		log.Println("readOne error reply ")
+		e.logger.Printf("readOne error reply")
 		return false
 	}
 	resp := new(Event)
 	resp.Header = make(map[string]string)
-	if v := hdr.Get("Content-Length"); v != "" {
+	if v := headerValue(hdr, "Content-Length"); v != "" {
 		length, err := strconv.Atoi(v)
 		if err != nil {
 			e.err <- err
@@ -90,147 +261,318 @@ func (e *EventSocket) readOne() bool {
 		}
 		resp.Body = string(b)
 	}
-	switch hdr.Get("Content-Type") {
+	switch ctype := headerValue(hdr, "Content-Type"); ctype {
 	case "command/reply":
-		reply := hdr.Get("Reply-Text")
-		if reply[0] == '%' {
-			copyHeaders(&hdr, resp, true)
+		reply := headerValue(hdr, "Reply-Text")
+		if strings.HasPrefix(reply, "%") {
+			copyHeaders(hdr, resp, true)
 		} else {
-			copyHeaders(&hdr, resp, false)
+			copyHeaders(hdr, resp, false)
 		}
-		log.Println("readOne command reply ")
-		e.cmd <- resp
+		e.logger.Printf("readOne command reply")
+		e.deliverReply(resp)
 	case "api/response":
-		copyHeaders(&hdr, resp, false)
-		log.Printf("readOne api reply : %v", resp.Header)
-		e.api <- resp
+		copyHeaders(hdr, resp, false)
+		e.logger.Printf("readOne api reply : %v", resp.Header)
+		e.deliverReply(resp)
 	case "auth/request":
-		copyHeaders(&hdr, resp, false)
+		copyHeaders(hdr, resp, false)
 		e.auth <- resp
-	case "text/event-plain":
-		reader := bufio.NewReader(bytes.NewReader([]byte(resp.Body)))
-		resp.Body = ""
-		textreader := textproto.NewReader(reader)
-		hdr, err = textreader.ReadMIMEHeader()
-		if err != nil {
-			e.err <- err
-			return false
-		}
-		if v := hdr.Get("Content-Length"); v != "" {
-			length, err := strconv.Atoi(v)
-			if err != nil {
-				e.err <- err
-				return false
-			}
-			b := make([]byte, length)
-			if _, err = io.ReadFull(reader, b); err != nil {
-				e.err <- err
-				return false
-			}
-			resp.Body = string(b)
-		}
-		copyHeaders(&hdr, resp, true)
-		log.Println("readOne event-plain reply ")
-		e.evt <- resp
-	case "text/event-json":
-		tmp := make(EventHeader)
-		err := json.Unmarshal([]byte(resp.Body), &tmp)
-		if err != nil {
-			e.err <- err
-			return false
-		}
-		// capitalize header keys for consistency.
-		for k, v := range tmp {
-			//resp.Header[capitalize(k)] = v
-			resp.Header[k] = v
+	case "log/data":
+		copyHeaders(hdr, resp, false)
+		select {
+		case e.logs <- newLogLine(resp):
+		default:
+			// The log handlers are behind; drop the line rather than
+			// hold up the command replies.
 		}
-		if v, _ := resp.Header["_body"]; v != "" {
-			resp.Body = v
-			delete(resp.Header, "_body")
-		} else {
-			resp.Body = ""
-		}
-		log.Println("readOne event-json reply")
-		e.evt <- resp
-	case "text/disconnect-notice":
-		copyHeaders(&hdr, resp, false)
-		log.Println("readOne disconnect reply ")
-		e.evt <- resp
-		return false
+	case "text/disconnect-notice", "text/rude-rejection":
+		// A rude rejection is sent by FreeSWITCH when the ACL refuses
+		// the connection, right before closing it.
+		copyHeaders(hdr, resp, false)
+		e.logger.Printf("readOne disconnect reply")
+		e.queueEvent(resp)
+		// After linger FreeSWITCH keeps sending the last events of the
+		// channel (CHANNEL_HANGUP_COMPLETE and its CDR variables) and
+		// closes the socket once done.
+		return resp.GetHeader("Content-Disposition", "") == "linger"
 	default:
-		copyHeaders(&hdr, resp, false)
-		log.Println("readOne default :")
-		e.evt <- resp
-		log.Fatal("Unsupported event:", hdr)
+		copyHeaders(hdr, resp, false)
+		e.decodeFrame(ctype, resp)
 	}
 	return true
 }
 
 // readLoop calls readOne until a fatal error occurs, then close the socket.
+// It also starts the writer and log goroutines, which live as long as the
+// read loop.
 func (e *EventSocket) readLoop() {
-
+	e.mtx.Lock()
+	conn, closed := e.conn, e.closed
+	e.mtx.Unlock()
+	go e.writeLoop(conn, closed)
+	go e.logLoop(closed)
 	for e.readOne() {
 
 	}
-	e.Disconnect()
+	conn.Close()
+	e.shutdown(closed)
 	//return
 }
 
-// ReadEvent reads and returns events from the server. It supports both plain
-// or json, but *not* XML.
-//
-// When subscribing to events (e.g. `Send("events json ALL")`) it makes no
-// difference to use plain or json. ReadEvent will parse them and return
-// all headers and the body (if any) in an Event struct.
-func (e *EventSocket) readEvent() (*Event, error) {
-	var (
-		ev  *Event
-		err error
-	)
+// deliverEvent resolves the background job or execute an event belongs
+// to, if any, and queues the event for readEvent. Events consumed that way
+// are only queued if there are handlers for them, so a socket that is
+// only used for commands doesn't pile them up.
+func (e *EventSocket) deliverEvent(ev *Event) {
+	consumed := false
+	if ev.GetHeader("Event-Name", "") == "BACKGROUND_JOB" {
+		uuid := ev.GetHeader("Job-UUID", "")
+		e.mtx.Lock()
+		job := e.jobs[uuid]
+		delete(e.jobs, uuid)
+		e.mtx.Unlock()
+		if job != nil {
+			job.resolve(ev, nil)
+			consumed = true
+		}
+	}
+	if ev.GetHeader("Event-Name", "") == "CHANNEL_EXECUTE_COMPLETE" {
+		uuid := ev.GetHeader("Application-UUID", "")
+		e.mtx.Lock()
+		waiter := e.executes[uuid]
+		delete(e.executes, uuid)
+		e.mtx.Unlock()
+		if waiter != nil {
+			waiter <- ev
+			consumed = true
+		}
+	}
+	e.mtx.Lock()
+	observers := e.observers
+	handled := len(e.handlersFor(ev.GetHeader("Event-Name", ""), ev.GetHeader("Event-Subclass", ""))) > 0
+	e.mtx.Unlock()
+	for _, fn := range observers {
+		fn(ev)
+	}
+	if consumed && !handled {
+		return
+	}
+	e.queueEvent(ev)
+}
+
+// queueEvent queues ev for readEvent. The queue has no bound: the read
+// loop must keep reading command replies even while nobody reads events,
+// e.g. during a reconnect.
+func (e *EventSocket) queueEvent(ev *Event) {
+	e.mtx.Lock()
+	e.queued = append(e.queued, ev)
+	e.mtx.Unlock()
 	select {
-	case ev = <-e.discon:
-		return nil, errors.New("Disconnected")
-	case ev = <-e.evt:
-		return ev, nil
-	case err = <-e.err:
-		return nil, err
+	case e.evt <- struct{}{}:
+	default:
+	}
+}
+
+// observe registers fn to be called from the read loop with every event,
+// before it is queued for readEvent. fn must not block.
+func (e *EventSocket) observe(fn func(*Event)) {
+	e.mtx.Lock()
+	e.observers = append(e.observers, fn)
+	e.mtx.Unlock()
+}
+
+// shutdown marks the connection as gone and fails every command, job and
+// execute waiter still pending on it, as their replies and events can no
+// longer be received.
+func (e *EventSocket) shutdown(closed chan struct{}) {
+	e.mtx.Lock()
+	pending, jobs, executes := e.pending, e.jobs, e.executes
+	e.pending = nil
+	e.jobs = make(map[string]*Job)
+	e.executes = make(map[string]chan *Event)
+	close(closed)
+	e.mtx.Unlock()
+	for _, req := range pending {
+		req.reply <- reply{err: errDisconnected}
+	}
+	for _, job := range jobs {
+		job.resolve(nil, errDisconnected)
+	}
+	for _, waiter := range executes {
+		close(waiter)
 	}
 }
 
-// Dispatch events to handlers in async mode
-func (self *EventSocket) dispatchEvent(event *Event) {
-	var eventName string
-	if eventName = event.GetHeader("Event-Name", ""); eventName != "" {
-		if _, hasHandlers := self.eventHandlers[eventName]; hasHandlers {
-			// We have handlers, dispatch to all of them
-			for _, handlerFunc := range self.eventHandlers[eventName] {
-				go handlerFunc(event)
+// writeLoop is the only goroutine writing commands to the socket. It keeps
+// at most depth commands in flight and records each written command in
+// pending, so replies (which FreeSWITCH sends in order) can be matched
+// back to their callers.
+func (e *EventSocket) writeLoop(conn net.Conn, closed chan struct{}) {
+	for {
+		e.mtx.Lock()
+		full := len(e.pending) >= e.depth
+		e.mtx.Unlock()
+		if full {
+			select {
+			case <-e.replied:
+			case <-closed:
 				return
 			}
+			continue
+		}
+		select {
+		case req := <-e.requests:
+			e.write(conn, closed, req)
+		case <-e.replied:
+		case <-closed:
+			return
 		}
 	}
+}
 
+// write sends a single request over the wire. Requests whose context is
+// already done are dropped without being written.
+func (e *EventSocket) write(conn net.Conn, closed chan struct{}, req *request) {
+	if err := req.ctx.Err(); err != nil {
+		req.reply <- reply{err: err}
+		return
+	}
+	e.mtx.Lock()
+	select {
+	case <-closed:
+		e.mtx.Unlock()
+		req.reply <- reply{err: errDisconnected}
+		return
+	default:
+	}
+	e.pending = append(e.pending, req)
+	e.mtx.Unlock()
+	if deadline, ok := req.ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+	if _, err := io.WriteString(conn, req.cmd); err != nil {
+		// A partial write leaves the stream unusable; drop the connection
+		// and let the read loop fail every pending request.
+		e.logger.Printf("Error writing command: %s", err)
+		conn.Close()
+	}
+}
+
+// deliverReply hands a command/reply or api/response to the oldest
+// pending request.
+func (e *EventSocket) deliverReply(ev *Event) {
+	e.mtx.Lock()
+	if len(e.pending) == 0 {
+		e.mtx.Unlock()
+		e.logger.Printf("Dropping unexpected reply: %v", ev.Header)
+		return
+	}
+	req := e.pending[0]
+	e.pending[0] = nil
+	e.pending = e.pending[1:]
+	e.mtx.Unlock()
+	req.reply <- reply{ev: ev}
+	select {
+	case e.replied <- struct{}{}:
+	default:
+	}
+}
+
+// SetPipelineDepth sets how many commands may be written before their
+// replies arrive. The default of 1 sends one command at a time; higher
+// values pipeline commands issued concurrently from several goroutines.
+func (e *EventSocket) SetPipelineDepth(n int) {
+	if n < 1 {
+		n = 1
+	}
+	e.mtx.Lock()
+	e.depth = n
+	e.mtx.Unlock()
+	select {
+	case e.replied <- struct{}{}:
+	default:
+	}
+}
+
+// ReadEvent reads and returns events from the server. It supports plain,
+// json and XML.
+//
+// When subscribing to events (e.g. `Send("events json ALL")`) it makes no
+// difference to use plain, json or xml. ReadEvent will parse them and return
+// all headers and the body (if any) in an Event struct.
+func (e *EventSocket) readEvent() (*Event, error) {
+	for {
+		// Queued events come first, even once the connection is gone.
+		e.mtx.Lock()
+		if len(e.queued) > 0 {
+			ev := e.queued[0]
+			e.queued[0] = nil
+			e.queued = e.queued[1:]
+			e.mtx.Unlock()
+			return ev, nil
+		}
+		closed := e.closed
+		e.mtx.Unlock()
+		select {
+		case <-e.evt:
+		case <-e.discon:
+			return nil, errDisconnected
+		case err := <-e.err:
+			return nil, err
+		case <-closed:
+			return nil, errDisconnected
+		}
+	}
 }
 
 func (e *EventSocket) Connected() bool {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
 	if e.conn == nil {
 		return false
 	}
+	select {
+	case <-e.closed:
+		return false
+	default:
+	}
 	return true
 }
 
 // Disconnects from socket
 func (e *EventSocket) Disconnect() (err error) {
-	if e.conn != nil {
-		err = e.conn.Close()
+	e.mtx.Lock()
+	conn := e.conn
+	e.mtx.Unlock()
+	if conn != nil {
+		err = conn.Close()
 	}
 	return err
 }
 func (e *EventSocket) ProtocolSendMsg(name, args, uuid string, Lock bool, loop int, asyn bool) (*Event, error) {
-	if !e.Connected() {
-		return nil, errors.New("Not connected to FS")
-	}
+	return e.ProtocolSendMsgContext(context.Background(), name, args, uuid, Lock, loop, asyn)
+}
 
+// ProtocolSendMsgContext is like ProtocolSendMsg but honors the deadline and
+// cancellation of ctx.
+func (e *EventSocket) ProtocolSendMsgContext(ctx context.Context, name, args, uuid string, Lock bool, loop int, asyn bool) (*Event, error) {
+	e.mtx.Lock()
+	wait := e.waitExecute
+	e.mtx.Unlock()
+	var appUUID string
+	var done chan *Event
+	if wait {
+		if err := e.ensureEvent(ctx, "CHANNEL_EXECUTE_COMPLETE"); err != nil {
+			return nil, err
+		}
+		appUUID = newUUID()
+		done = make(chan *Event, 1)
+		e.mtx.Lock()
+		e.executes[appUUID] = done
+		e.mtx.Unlock()
+	}
 	msg := fmt.Sprintf("sendmsg %s\ncall-command: execute\n", uuid)
 	msg += fmt.Sprintf("execute-app-name: %s\n", name)
 	if Lock {
@@ -242,54 +584,151 @@ func (e *EventSocket) ProtocolSendMsg(name, args, uuid string, Lock bool, loop i
 	if asyn {
 		msg += fmt.Sprintf("async: %t\n", asyn)
 	}
+	if appUUID != "" {
+		msg += fmt.Sprintf("event-uuid: %s\n", appUUID)
+	}
 	if args != "" {
 		arglen := len(args)
-		msg += fmt.Sprintf("content-type: text/plain\ncontent-length: %d\n\n%s\n", arglen, args)
+		msg += fmt.Sprintf("content-type: text/plain\ncontent-length: %d\n\n%s", arglen, args)
+	} else {
+		msg += "\n"
+	}
+	e.logger.Printf("Sending SendMSG: %s", msg)
+	ev, err := e.send(ctx, "sendmsg", msg)
+	if done == nil {
+		return ev, err
+	}
+	if err != nil || !ev.IsReplyTextSuccess() {
+		e.forgetExecute(appUUID)
+		return ev, err
 	}
-	log.Printf("Sending SendMSG: %s", msg)
-	fmt.Fprintf(e.conn, "%s\r\n\r\n", msg)
-	var (
-		ev *Event
-	)
 	select {
-	case ev = <-e.cmd:
-		return ev, nil
+	case complete, ok := <-done:
+		if !ok {
+			return nil, errDisconnected
+		}
+		return complete, nil
+	case <-ctx.Done():
+		e.forgetExecute(appUUID)
+		return nil, contextError(name, ctx.Err())
 	}
+}
 
+// SetWaitForCompletion makes every execute sent through ProtocolSendMsg
+// (Playback, Speak, Record, PlayAndGetDigits, ...) block until the
+// application has finished instead of returning once FreeSWITCH accepted
+// the sendmsg. Each execute is tagged with an Event-UUID and the socket
+// subscribes to CHANNEL_EXECUTE_COMPLETE if needed.
+//
+// While enabled the returned Event is the matching CHANNEL_EXECUTE_COMPLETE,
+// which carries the Application-Response header and the channel variables,
+// e.g. the digits collected by PlayAndGetDigits in variable_<digitVarName>.
+func (e *EventSocket) SetWaitForCompletion(wait bool) {
+	e.mtx.Lock()
+	e.waitExecute = wait
+	e.mtx.Unlock()
+}
+
+// forgetExecute drops the waiter for an execute nobody waits on anymore.
+func (e *EventSocket) forgetExecute(appUUID string) {
+	e.mtx.Lock()
+	delete(e.executes, appUUID)
+	e.mtx.Unlock()
 }
 func (e *EventSocket) ProtocolSend(command, args string) (*Event, error) {
+	return e.ProtocolSendContext(context.Background(), command, args)
+}
+
+// ProtocolSendContext is like ProtocolSend but honors the deadline and
+// cancellation of ctx.
+func (e *EventSocket) ProtocolSendContext(ctx context.Context, command, args string) (*Event, error) {
+	// Trailing blank lines would be read by FreeSWITCH as extra commands
+	// and throw reply correlation off.
+	cmd := strings.TrimSpace(fmt.Sprintf("%s %s", command, args))
+	return e.send(ctx, command, cmd+"\n\n")
+}
+
+// send queues cmd for the writer goroutine and waits for its reply. It is
+// safe to call from many goroutines at once: each caller receives the reply
+// to its own command. If ctx is done first the caller returns immediately
+// and the late reply is discarded when it arrives.
+func (e *EventSocket) send(ctx context.Context, command, cmd string) (*Event, error) {
 	if !e.Connected() {
-		return nil, errors.New("Not connected to FS")
+		return nil, errNotConnected
 	}
-	cmd := fmt.Sprintf("%s %s", command, args)
-	fmt.Fprintf(e.conn, "%s\r\n\r\n", cmd)
-	var (
-		ev *Event
-	)
+	req := &request{ctx: ctx, cmd: cmd, reply: make(chan reply, 1)}
 	select {
-
-	case ev = <-e.cmd:
-		return ev, nil
-	case ev = <-e.api:
-		return ev, nil
+	case e.requests <- req:
+	case <-e.done():
+		return nil, errDisconnected
+	case <-ctx.Done():
+		return nil, contextError(command, ctx.Err())
+	}
+	select {
+	case r := <-req.reply:
+		if r.err != nil && r.err == ctx.Err() {
+			return nil, contextError(command, r.err)
+		}
+		if r.ev != nil {
+			if reply, denied := permissionDenied(r.ev); denied {
+				return r.ev, &PermissionError{Command: command, Reply: reply}
+			}
+		}
+		return r.ev, r.err
+	case <-ctx.Done():
+		return nil, contextError(command, ctx.Err())
 	}
 }
 func (e *EventSocket) APICommand(args string) (*Event, error) {
 	//"Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#api"
-	var evt, err = e.ProtocolSend("api", args)
-	return evt, err
+	return e.APICommandContext(context.Background(), args)
+}
 
+// APICommandContext is like APICommand but honors the deadline and cancellation of ctx.
+func (e *EventSocket) APICommandContext(ctx context.Context, args string) (*Event, error) {
+	var evt, err = e.ProtocolSendContext(ctx, "api", args)
+	return evt, err
 }
-func (e *EventSocket) BgAPICommand(args string) (*Event, error) {
+func (e *EventSocket) BgAPICommand(args string) (*Job, error) {
 	//"Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#bgapi"
-	var evt, err = e.ProtocolSend("bgapi", args)
+	return e.BgAPICommandContext(context.Background(), args)
+}
 
-	return evt, err
+// BgAPICommandContext is like BgAPICommand but honors the deadline and cancellation of ctx.
+//
+// The command is sent with a Job-UUID of our own and the socket subscribes
+// to BACKGROUND_JOB if needed. The returned Job completes when the matching
+// BACKGROUND_JOB event arrives.
+func (e *EventSocket) BgAPICommandContext(ctx context.Context, args string) (*Job, error) {
+	if err := e.ensureEvent(ctx, "BACKGROUND_JOB"); err != nil {
+		return nil, err
+	}
+	job := newJob(newUUID())
+	e.mtx.Lock()
+	e.jobs[job.UUID] = job
+	e.mtx.Unlock()
+	cmd := fmt.Sprintf("bgapi %s\nJob-UUID: %s\n\n", strings.TrimSpace(args), job.UUID)
+	ev, err := e.send(ctx, "bgapi", cmd)
+	if err != nil || !ev.IsReplyTextSuccess() {
+		e.mtx.Lock()
+		delete(e.jobs, job.UUID)
+		e.mtx.Unlock()
+		if err == nil {
+			err = errors.New(ev.GetReplyText())
+		}
+		return nil, err
+	}
+	job.Reply = ev
+	return job, nil
 }
 func (e *EventSocket) Exit() (*Event, error) {
 	//"Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#exit"
-	var evt, err = e.ProtocolSend("exit", "")
+	return e.ExitContext(context.Background())
+}
 
+// ExitContext is like Exit but honors the deadline and cancellation of ctx.
+func (e *EventSocket) ExitContext(ctx context.Context) (*Event, error) {
+	var evt, err = e.ProtocolSendContext(ctx, "exit", "")
 	return evt, err
 }
 func (e *EventSocket) Resume() (*Event, error) {
@@ -300,37 +739,247 @@ func (e *EventSocket) Resume() (*Event, error) {
 	   after the call to the socket application.
 
 	   If there is a bridge active when the disconnect happens, it is killed.*/
-	return e.ProtocolSend("resume", "")
+	return e.ResumeContext(context.Background())
+}
+
+// ResumeContext is like Resume but honors the deadline and cancellation of ctx.
+func (e *EventSocket) ResumeContext(ctx context.Context) (*Event, error) {
+	return e.ProtocolSendContext(ctx, "resume", "")
 }
 func (e *EventSocket) ChannelConnect() (*Event, error) {
 	//""Socket connect for Outbound connection only.
-	return e.ProtocolSend("connect", "")
+	return e.ChannelConnectContext(context.Background())
+}
+
+// ChannelConnectContext is like ChannelConnect but honors the deadline and cancellation of ctx.
+func (e *EventSocket) ChannelConnectContext(ctx context.Context) (*Event, error) {
+	return e.ProtocolSendContext(ctx, "connect", "")
 }
 func (e *EventSocket) EventPlain(args string) (*Event, error) {
 	//"Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#event"
-	return e.ProtocolSend("event plain", args)
+	return e.EventPlainContext(context.Background(), args)
+}
+
+// EventPlainContext is like EventPlain but honors the deadline and cancellation of ctx.
+func (e *EventSocket) EventPlainContext(ctx context.Context, args string) (*Event, error) {
+	return e.subscribe(ctx, "plain", args)
 }
 func (e *EventSocket) EventJson(args string) (*Event, error) {
 	//"Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#event"
-	return e.ProtocolSend("event json", args)
+	return e.EventJsonContext(context.Background(), args)
+}
+
+// EventJsonContext is like EventJson but honors the deadline and cancellation of ctx.
+func (e *EventSocket) EventJsonContext(ctx context.Context, args string) (*Event, error) {
+	return e.subscribe(ctx, "json", args)
+}
+func (e *EventSocket) EventXML(args string) (*Event, error) {
+	//"Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#event"
+	return e.EventXMLContext(context.Background(), args)
+}
+
+// EventXMLContext is like EventXML but honors the deadline and cancellation of ctx.
+func (e *EventSocket) EventXMLContext(ctx context.Context, args string) (*Event, error) {
+	return e.subscribe(ctx, "xml", args)
 }
 func (e *EventSocket) Event(args string) (*Event, error) {
 	//"Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#event"
-	return e.ProtocolSend("event", args)
+	return e.EventContext(context.Background(), args)
+}
+
+// EventContext is like Event but honors the deadline and cancellation of ctx.
+func (e *EventSocket) EventContext(ctx context.Context, args string) (*Event, error) {
+	if fields := strings.Fields(args); len(fields) > 0 {
+		switch fields[0] {
+		case "plain", "json", "xml":
+			return e.subscribe(ctx, fields[0], strings.Join(fields[1:], " "))
+		}
+	}
+	return e.subscribe(ctx, "plain", args)
+}
+
+func (e *EventSocket) NixEvent(args string) (*Event, error) {
+	//"Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#nixevent"
+	return e.NixEventContext(context.Background(), args)
+}
+
+// NixEventContext is like NixEvent but honors the deadline and cancellation of ctx.
+func (e *EventSocket) NixEventContext(ctx context.Context, args string) (*Event, error) {
+	ev, err := e.ProtocolSendContext(ctx, "nixevent", args)
+	if err != nil || !ev.IsReplyTextSuccess() {
+		return ev, err
+	}
+	e.mtx.Lock()
+	names := strings.Fields(args)
+	for _, name := range names {
+		// CUSTOM only introduces the subclasses when followed by some.
+		if name != "CUSTOM" || len(names) == 1 {
+			delete(e.events, name)
+		}
+	}
+	e.mtx.Unlock()
+	return ev, nil
+}
+
+// subscribe sends an event command and remembers the format and event
+// names, so the socket knows what it is already subscribed to.
+func (e *EventSocket) subscribe(ctx context.Context, format, names string) (*Event, error) {
+	ev, err := e.ProtocolSendContext(ctx, "event "+format, names)
+	if err != nil || !ev.IsReplyTextSuccess() {
+		return ev, err
+	}
+	e.mtx.Lock()
+	e.format = format
+	for _, name := range strings.Fields(names) {
+		e.events[name] = true
+	}
+	e.mtx.Unlock()
+	return ev, nil
+}
+
+// eventList formats event names for an event command. Subclasses must
+// follow the CUSTOM keyword, so they go last. Names are event names or
+// handler keys such as "CUSTOM sofia::register".
+func eventList(names map[string]bool) string {
+	if names["ALL"] {
+		return "ALL"
+	}
+	plain := make(map[string]bool)
+	subclasses := make(map[string]bool)
+	custom := false
+	for key := range names {
+		name, subclass := splitEventKey(key)
+		switch {
+		case subclass != "":
+			subclasses[subclass] = true
+		case name == "CUSTOM":
+			custom = true
+		default:
+			plain[name] = true
+		}
+	}
+	list := sortedKeys(plain)
+	if custom || len(subclasses) > 0 {
+		list = append(append(list, "CUSTOM"), sortedKeys(subclasses)...)
+	}
+	return strings.Join(list, " ")
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitEventKey splits a handler key into the event name and subclass.
+// CUSTOM events are keyed "CUSTOM <subclass>", e.g. "CUSTOM sofia::register";
+// a bare subclass such as "sofia::register" means the same.
+func splitEventKey(key string) (name, subclass string) {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(key, "CUSTOM ") {
+		return "CUSTOM", strings.TrimSpace(key[len("CUSTOM "):])
+	}
+	if strings.Contains(key, "::") {
+		return "CUSTOM", key
+	}
+	return key, ""
+}
+
+// eventKey returns the handler key for the event name and subclass.
+func eventKey(name, subclass string) string {
+	if subclass != "" {
+		return name + " " + subclass
+	}
+	return name
+}
+
+// subscribedName returns the name a subscription to the event of a handler
+// key is recorded under: the subclass of CUSTOM events, else the name.
+func subscribedName(key string) string {
+	name, subclass := splitEventKey(key)
+	if subclass != "" {
+		return subclass
+	}
+	return name
+}
+
+// replay restores the subscriptions, filters, divert_events and myevents
+// recorded on the socket, after a new connection has been attached.
+func (e *EventSocket) replay(ctx context.Context) error {
+	e.mtx.Lock()
+	var cmds [][2]string
+	if len(e.events) > 0 {
+		cmds = append(cmds, [2]string{"event " + e.format, eventList(e.events)})
+	}
+	for _, f := range e.filters {
+		cmds = append(cmds, [2]string{"filter", f.String()})
+	}
+	if e.divert != "" {
+		cmds = append(cmds, [2]string{"divert_events", e.divert})
+	}
+	for _, uuid := range e.myevents {
+		cmds = append(cmds, [2]string{"myevents", uuid})
+	}
+	e.mtx.Unlock()
+	for _, cmd := range cmds {
+		ev, err := e.ProtocolSendContext(ctx, cmd[0], cmd[1])
+		if err != nil {
+			return err
+		}
+		if !ev.IsReplyTextSuccess() {
+			return fmt.Errorf("%s %s: %s", cmd[0], cmd[1], ev.GetReplyText())
+		}
+	}
+	return nil
+}
+
+// ensureEvent subscribes to name in the current event format unless the
+// socket already receives it, and lets it through the active filters.
+func (e *EventSocket) ensureEvent(ctx context.Context, name string) error {
+	e.mtx.Lock()
+	subscribed := e.events["ALL"] || e.events[name]
+	format := e.format
+	// The socket relies on the event now; removing the handlers must not
+	// unsubscribe from it.
+	delete(e.handlerEvents, name)
+	e.mtx.Unlock()
+	if !subscribed {
+		ev, err := e.subscribe(ctx, format, name)
+		if err != nil {
+			return err
+		}
+		if !ev.IsReplyTextSuccess() {
+			return errSubscribeFailed
+		}
+	}
+	return e.keepEvent(ctx, name)
 }
 func (e *EventSocket) DigitActionSetRealm(args, uuid string, islock bool) (*Event, error) {
 	/*Please refer to http://wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_digit_action_set_realm
 	  >>> digit_action_set_realm("test1")
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("digit_action_set_realm", args, uuid, islock, 0, false)
+	return e.DigitActionSetRealmContext(context.Background(), args, uuid, islock)
+}
+
+// DigitActionSetRealmContext is like DigitActionSetRealm but honors the deadline and cancellation of ctx.
+func (e *EventSocket) DigitActionSetRealmContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "digit_action_set_realm", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) ClearDigitAction(args, uuid string, islock bool) (*Event, error) {
 	/*>>> clear_digit_action("test1")
 
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("clear_digit_action", args, uuid, islock, 0, false)
+	return e.ClearDigitActionContext(context.Background(), args, uuid, islock)
+}
+
+// ClearDigitActionContext is like ClearDigitAction but honors the deadline and cancellation of ctx.
+func (e *EventSocket) ClearDigitActionContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "clear_digit_action", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) Filter(args string) (*Event, error) {
 	/*   "Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#filter
@@ -342,14 +991,62 @@ func (e *EventSocket) Filter(args string) (*Event, error) {
 	     >>> filter('Event-Name MYEVENT')
 	     >>> filter('Unique-ID 4f37c5eb-1937-45c6-b808-6fba2ffadb63')
 	     """*/
-	return e.ProtocolSend("filter", args)
+	return e.FilterContext(context.Background(), args)
+}
+
+// FilterContext is like Filter but honors the deadline and cancellation of ctx.
+//
+// A filter that is already active is not sent again; the reply is then
+// made up locally.
+func (e *EventSocket) FilterContext(ctx context.Context, args string) (*Event, error) {
+	f := parseFilter(args)
+	if e.hasFilter(f) {
+		return &Event{Header: map[string]string{
+			"Content-Type": "command/reply",
+			"Reply-Text":   "+OK filter already active",
+		}}, nil
+	}
+	ev, err := e.ProtocolSendContext(ctx, "filter", args)
+	if err == nil && ev.IsReplyTextSuccess() && !e.hasFilter(f) {
+		e.mtx.Lock()
+		e.filters = append(e.filters, f)
+		e.mtx.Unlock()
+	}
+	return ev, err
 }
 func (e *EventSocket) FilterDelete(args string) (*Event, error) {
 	/*  "Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#filter_delete
 
 	    >>> filter_delete('Event-Name MYEVENT')
 	    """ */
-	return e.ProtocolSend("filter delete", args)
+	return e.FilterDeleteContext(context.Background(), args)
+}
+
+// FilterDeleteContext is like FilterDelete but honors the deadline and cancellation of ctx.
+func (e *EventSocket) FilterDeleteContext(ctx context.Context, args string) (*Event, error) {
+	ev, err := e.ProtocolSendContext(ctx, "filter delete", args)
+	if err == nil && ev.IsReplyTextSuccess() {
+		e.mtx.Lock()
+		var kept []EventFilter
+		for _, f := range e.filters {
+			// "filter delete <header>" drops every filter on that header.
+			if !f.matches(args) {
+				kept = append(kept, f)
+			}
+		}
+		e.filters = kept
+		e.mtx.Unlock()
+	}
+	return ev, err
+}
+func (e *EventSocket) FilterDeleteAll() (*Event, error) {
+	//"Removes every filter, so all subscribed events are sent again."
+	return e.FilterDeleteAllContext(context.Background())
+}
+
+// FilterDeleteAllContext is like FilterDeleteAll but honors the deadline and cancellation of ctx.
+func (e *EventSocket) FilterDeleteAllContext(ctx context.Context) (*Event, error) {
+	return e.FilterDeleteContext(ctx, "all")
 }
 func (e *EventSocket) DivertEvents(flag string) (*Event, error) {
 	/*   "Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#divert_events
@@ -357,9 +1054,20 @@ func (e *EventSocket) DivertEvents(flag string) (*Event, error) {
 	     >>> divert_events("off")
 	     >>> divert_events("on")
 	     """*/
-	return e.ProtocolSend("divert_events", flag)
+	return e.DivertEventsContext(context.Background(), flag)
+}
+
+// DivertEventsContext is like DivertEvents but honors the deadline and cancellation of ctx.
+func (e *EventSocket) DivertEventsContext(ctx context.Context, flag string) (*Event, error) {
+	ev, err := e.ProtocolSendContext(ctx, "divert_events", flag)
+	if err == nil && ev.IsReplyTextSuccess() {
+		e.mtx.Lock()
+		e.divert = strings.TrimSpace(flag)
+		e.mtx.Unlock()
+	}
+	return ev, err
 }
-func (e *EventSocket) SendEvent(args string) (*Event, error) {
+func (e *EventSocket) SendEventRaw(args string) (*Event, error) {
 	/*   "Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#sendevent
 
 	     >>> sendevent("CUSTOM\nEvent-Name; CUSTOM\nEvent-Subclass; myevent;;test\n")
@@ -369,13 +1077,91 @@ func (e *EventSocket) SendEvent(args string) (*Event, error) {
 	       Command; sendevent%20CUSTOM
 	       Event-Name; CUSTOM
 	     """ */
-	return e.ProtocolSend("sendevent", args)
+	return e.SendEventRawContext(context.Background(), args)
+}
+
+// SendEventRawContext is like SendEventRaw but honors the deadline and cancellation of ctx.
+func (e *EventSocket) SendEventRawContext(ctx context.Context, args string) (*Event, error) {
+	return e.ProtocolSendContext(ctx, "sendevent", args)
 }
+
+// SendEvent fires ev in FreeSWITCH and returns its Event-UUID, as
+// reported in the reply, or "" if FreeSWITCH doesn't report it. The event
+// is named by its Event-Name header, CUSTOM if it only has an
+// Event-Subclass, and its body is sent with a Content-Length. FreeSWITCH
+// gives the event an Event-UUID of its own, so an Event-UUID in ev is not
+// sent. Header values are sent as they are, so they can't contain line
+// breaks.
+func (e *EventSocket) SendEvent(ctx context.Context, ev *Event) (string, error) {
+	name := ev.GetHeader("Event-Name", "")
+	if name == "" && ev.GetHeader("Event-Subclass", "") != "" {
+		name = "CUSTOM"
+	}
+	if name == "" {
+		return "", errNoEventName
+	}
+	msg := fmt.Sprintf("sendevent %s\n", name)
+	for _, k := range ev.headerKeys() {
+		if strings.EqualFold(k, "Event-Name") || strings.EqualFold(k, "Event-UUID") {
+			continue
+		}
+		v := ev.Header[k]
+		if strings.ContainsAny(k+v, "\r\n") {
+			return "", &HeaderError{Header: k, Value: v, Err: errLineBreak}
+		}
+		msg += fmt.Sprintf("%s: %s\n", k, v)
+	}
+	if ev.Body != "" {
+		msg += fmt.Sprintf("Content-Length: %d\n\n%s", len(ev.Body), ev.Body)
+	} else {
+		msg += "\n"
+	}
+	reply, err := e.send(ctx, "sendevent", msg)
+	if err != nil {
+		return "", err
+	}
+	text := reply.GetReplyText()
+	if !strings.HasPrefix(text, "+OK") {
+		return "", errors.New(text)
+	}
+	// FreeSWITCH replies with the Event-UUID of the event it fired.
+	if fields := strings.Fields(text); len(fields) > 1 {
+		return fields[1], nil
+	}
+	return "", nil
+}
+
 func (e *EventSocket) Auth(args string) (*Event, error) {
 	/* "Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#auth
 
-																																																																																																																																																																																																																																																																						     This method is only used for Inbound connections. */
-	return e.ProtocolSend("auth", args)
+	   This method is only used for Inbound connections. */
+	return e.AuthContext(context.Background(), args)
+}
+
+// AuthContext is like Auth but honors the deadline and cancellation of ctx.
+func (e *EventSocket) AuthContext(ctx context.Context, args string) (*Event, error) {
+	ev, err := e.ProtocolSendContext(ctx, "auth", args)
+	if err == nil && !strings.HasPrefix(ev.GetReplyText(), "+OK") {
+		err = &AuthError{Reply: ev.GetReplyText()}
+	}
+	return ev, err
+}
+func (e *EventSocket) UserAuth(user, domain, password string) (*Event, error) {
+	/* Please refer to http://wiki.freeswitch.org/wiki/Event_Socket#userauth
+
+	   Authenticates as a directory user, whose esl-allowed-api and
+	   esl-allowed-events params restrict what the connection may do.
+	   This method is only used for Inbound connections. */
+	return e.UserAuthContext(context.Background(), user, domain, password)
+}
+
+// UserAuthContext is like UserAuth but honors the deadline and cancellation of ctx.
+func (e *EventSocket) UserAuthContext(ctx context.Context, user, domain, password string) (*Event, error) {
+	ev, err := e.ProtocolSendContext(ctx, "userauth", fmt.Sprintf("%s@%s:%s", user, domain, password))
+	if err == nil && !strings.HasPrefix(ev.GetReplyText(), "+OK") {
+		err = &AuthError{User: user + "@" + domain, Reply: ev.GetReplyText()}
+	}
+	return ev, err
 }
 func (e *EventSocket) MyEvent(uuid string) (*Event, error) {
 	/*   """For Inbound connection, please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#Special_Case_-_.27myevents.27
@@ -386,7 +1172,25 @@ func (e *EventSocket) MyEvent(uuid string) (*Event, error) {
 
 	     For Inbound connection, uuid argument is mandatory.
 	     """ */
-	return e.ProtocolSend("myevents", uuid)
+	return e.MyEventContext(context.Background(), uuid)
+}
+
+// MyEventContext is like MyEvent but honors the deadline and cancellation of ctx.
+func (e *EventSocket) MyEventContext(ctx context.Context, uuid string) (*Event, error) {
+	ev, err := e.ProtocolSendContext(ctx, "myevents", uuid)
+	if err == nil && ev.IsReplyTextSuccess() {
+		uuid = strings.TrimSpace(uuid)
+		e.mtx.Lock()
+		found := false
+		for _, u := range e.myevents {
+			found = found || u == uuid
+		}
+		if !found {
+			e.myevents = append(e.myevents, uuid)
+		}
+		e.mtx.Unlock()
+	}
+	return ev, err
 }
 func (e *EventSocket) Linger() (*Event, error) {
 	/*   """Tell Freeswitch to wait for the last channel event before ending the connection
@@ -396,7 +1200,39 @@ func (e *EventSocket) Linger() (*Event, error) {
 	     >>> linger()
 
 	     """ */
-	return e.ProtocolSend("linger", "")
+	return e.LingerContext(context.Background())
+}
+
+// LingerContext is like Linger but honors the deadline and cancellation of ctx.
+func (e *EventSocket) LingerContext(ctx context.Context) (*Event, error) {
+	return e.ProtocolSendContext(ctx, "linger", "")
+}
+func (e *EventSocket) Log(level string) (*Event, error) {
+	/*   Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#log
+
+	     >>> log("7")
+	     >>> log("debug")
+
+	     Log lines are delivered to the functions registered with OnLog.
+	*/
+	return e.LogContext(context.Background(), level)
+}
+
+// LogContext is like Log but honors the deadline and cancellation of ctx.
+func (e *EventSocket) LogContext(ctx context.Context, level string) (*Event, error) {
+	return e.ProtocolSendContext(ctx, "log", level)
+}
+func (e *EventSocket) NoLog() (*Event, error) {
+	/*   Please refer to http;//wiki.freeswitch.org/wiki/Event_Socket#nolog
+
+	     Stops the log lines enabled by log().
+	*/
+	return e.NoLogContext(context.Background())
+}
+
+// NoLogContext is like NoLog but honors the deadline and cancellation of ctx.
+func (e *EventSocket) NoLogContext(ctx context.Context) (*Event, error) {
+	return e.ProtocolSendContext(ctx, "nolog", "")
 }
 func (e *EventSocket) VerboseEvents(uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_verbose_events
@@ -405,7 +1241,12 @@ func (e *EventSocket) VerboseEvents(uuid string, islock bool) (*Event, error) {
 
 	  For Inbound connection, uuid argument is mandatory.
 	  """ */
-	return e.ProtocolSendMsg("verbose_events", "", uuid, islock, 0, false)
+	return e.VerboseEventsContext(context.Background(), uuid, islock)
+}
+
+// VerboseEventsContext is like VerboseEvents but honors the deadline and cancellation of ctx.
+func (e *EventSocket) VerboseEventsContext(ctx context.Context, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "verbose_events", "", uuid, islock, 0, false)
 }
 func (e *EventSocket) Answer(uuid string, islock bool) (*Event, error) {
 	/*   Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_answer
@@ -414,7 +1255,12 @@ func (e *EventSocket) Answer(uuid string, islock bool) (*Event, error) {
 
 	     For Inbound connection, uuid argument is mandatory.
 	     """ */
-	return e.ProtocolSendMsg("answer", "", uuid, islock, 0, false)
+	return e.AnswerContext(context.Background(), uuid, islock)
+}
+
+// AnswerContext is like Answer but honors the deadline and cancellation of ctx.
+func (e *EventSocket) AnswerContext(ctx context.Context, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "answer", "", uuid, islock, 0, false)
 }
 func (e *EventSocket) Bridge(args, uuid string, islock bool) (*Event, error) {
 	/*
@@ -424,7 +1270,12 @@ func (e *EventSocket) Bridge(args, uuid string, islock bool) (*Event, error) {
 
 	   For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("bridge", args, uuid, islock, 0, false)
+	return e.BridgeContext(context.Background(), args, uuid, islock)
+}
+
+// BridgeContext is like Bridge but honors the deadline and cancellation of ctx.
+func (e *EventSocket) BridgeContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "bridge", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) Hangup(cause, uuid string, islock bool) (*Event, error) {
 	/* """Hangup call.
@@ -435,8 +1286,13 @@ func (e *EventSocket) Hangup(cause, uuid string, islock bool) (*Event, error) {
 
 	   For Inbound connection, uuid argument is mandatory.
 	   """ */
-	log.Println("Hanging up")
-	return e.ProtocolSendMsg("hangup", cause, uuid, islock, 0, false)
+	return e.HangupContext(context.Background(), cause, uuid, islock)
+}
+
+// HangupContext is like Hangup but honors the deadline and cancellation of ctx.
+func (e *EventSocket) HangupContext(ctx context.Context, cause, uuid string, islock bool) (*Event, error) {
+	e.logger.Printf("Hanging up")
+	return e.ProtocolSendMsgContext(ctx, "hangup", cause, uuid, islock, 0, false)
 }
 func (e *EventSocket) RingReady(cause, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_ring_ready
@@ -445,14 +1301,24 @@ func (e *EventSocket) RingReady(cause, uuid string, islock bool) (*Event, error)
 
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("ring_ready", "", uuid, true, 0, false)
+	return e.RingReadyContext(context.Background(), cause, uuid, islock)
+}
+
+// RingReadyContext is like RingReady but honors the deadline and cancellation of ctx.
+func (e *EventSocket) RingReadyContext(ctx context.Context, cause, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "ring_ready", "", uuid, true, 0, false)
 }
 func (e *EventSocket) RecordSession(filename, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_record_session
 	  >>> record_session("/tmp/dump.gsm")
 	  For Inbound connection, uuid argument is mandatory.
 	  """ */
-	return e.ProtocolSendMsg("record_session", filename, uuid, islock, 0, false)
+	return e.RecordSessionContext(context.Background(), filename, uuid, islock)
+}
+
+// RecordSessionContext is like RecordSession but honors the deadline and cancellation of ctx.
+func (e *EventSocket) RecordSessionContext(ctx context.Context, filename, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "record_session", filename, uuid, islock, 0, false)
 }
 func (e *EventSocket) BindMetaApp(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_bind_meta_app
@@ -461,14 +1327,24 @@ func (e *EventSocket) BindMetaApp(args, uuid string, islock bool) (*Event, error
 
 	  For Inbound connection, uuid argument is mandatory.
 	  """ */
-	return e.ProtocolSendMsg("bind_meta_app", args, uuid, islock, 0, false)
+	return e.BindMetaAppContext(context.Background(), args, uuid, islock)
+}
+
+// BindMetaAppContext is like BindMetaApp but honors the deadline and cancellation of ctx.
+func (e *EventSocket) BindMetaAppContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "bind_meta_app", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) BindDigitAction(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_bind_digit_action
 	  >>> bind_digit_action("test1,456,exec;playback,ivr/ivr-welcome_to_freeswitch.wav")
 	  For Inbound connection, uuid argument is mandatory.
 	  """ */
-	return e.ProtocolSendMsg("bind_digit_action", args, uuid, islock, 0, false)
+	return e.BindDigitActionContext(context.Background(), args, uuid, islock)
+}
+
+// BindDigitActionContext is like BindDigitAction but honors the deadline and cancellation of ctx.
+func (e *EventSocket) BindDigitActionContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "bind_digit_action", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) WaitForSilence(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_wait_for_silence
@@ -477,7 +1353,12 @@ func (e *EventSocket) WaitForSilence(args, uuid string, islock bool) (*Event, er
 
 	  For Inbound connection, uuid argument is mandatory.
 	  """ */
-	return e.ProtocolSendMsg("wait_for_silence", args, uuid, islock, 0, false)
+	return e.WaitForSilenceContext(context.Background(), args, uuid, islock)
+}
+
+// WaitForSilenceContext is like WaitForSilence but honors the deadline and cancellation of ctx.
+func (e *EventSocket) WaitForSilenceContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "wait_for_silence", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) Sleep(milliseconds, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_sleep
@@ -487,7 +1368,12 @@ func (e *EventSocket) Sleep(milliseconds, uuid string, islock bool) (*Event, err
 
 	  For Inbound connection, uuid argument is mandatory.
 	  """*/
-	return e.ProtocolSendMsg("sleep", milliseconds, uuid, islock, 0, false)
+	return e.SleepContext(context.Background(), milliseconds, uuid, islock)
+}
+
+// SleepContext is like Sleep but honors the deadline and cancellation of ctx.
+func (e *EventSocket) SleepContext(ctx context.Context, milliseconds, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "sleep", milliseconds, uuid, islock, 0, false)
 }
 func (e *EventSocket) Vmd(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Mod_vmd
@@ -495,7 +1381,12 @@ func (e *EventSocket) Vmd(args, uuid string, islock bool) (*Event, error) {
 	  >>> vmd("stop")
 	  For Inbound connection, uuid argument is mandatory.
 	  """ */
-	return e.ProtocolSendMsg("vmd", args, uuid, islock, 0, false)
+	return e.VmdContext(context.Background(), args, uuid, islock)
+}
+
+// VmdContext is like Vmd but honors the deadline and cancellation of ctx.
+func (e *EventSocket) VmdContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "vmd", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) Set(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_set
@@ -503,7 +1394,12 @@ func (e *EventSocket) Set(args, uuid string, islock bool) (*Event, error) {
 
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("set", args, uuid, islock, 0, false)
+	return e.SetContext(context.Background(), args, uuid, islock)
+}
+
+// SetContext is like Set but honors the deadline and cancellation of ctx.
+func (e *EventSocket) SetContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "set", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) Export(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_export
@@ -511,49 +1407,84 @@ func (e *EventSocket) Export(args, uuid string, islock bool) (*Event, error) {
 
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("export", args, uuid, islock, 0, false)
+	return e.ExportContext(context.Background(), args, uuid, islock)
+}
+
+// ExportContext is like Export but honors the deadline and cancellation of ctx.
+func (e *EventSocket) ExportContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "export", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) SetGlobal(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_set_global
 	  >>> set_global("global_var=value")
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("set_global", args, uuid, islock, 0, false)
+	return e.SetGlobalContext(context.Background(), args, uuid, islock)
+}
+
+// SetGlobalContext is like SetGlobal but honors the deadline and cancellation of ctx.
+func (e *EventSocket) SetGlobalContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "set_global", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) Unset(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_unset
 	  >>> unset("ringback")
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("unset", args, uuid, islock, 0, false)
+	return e.UnsetContext(context.Background(), args, uuid, islock)
+}
+
+// UnsetContext is like Unset but honors the deadline and cancellation of ctx.
+func (e *EventSocket) UnsetContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "unset", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) StartDtmf(uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_start_dtmf
 	  >>> start_dtmf()
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("start_dtmf", "", uuid, islock, 0, false)
+	return e.StartDtmfContext(context.Background(), uuid, islock)
+}
+
+// StartDtmfContext is like StartDtmf but honors the deadline and cancellation of ctx.
+func (e *EventSocket) StartDtmfContext(ctx context.Context, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "start_dtmf", "", uuid, islock, 0, false)
 }
 func (e *EventSocket) StopDtmf(uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_stop_dtmf
 	  >>> stop_dtmf()
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("stop_dtmf", "", uuid, islock, 0, false)
+	return e.StopDtmfContext(context.Background(), uuid, islock)
+}
+
+// StopDtmfContext is like StopDtmf but honors the deadline and cancellation of ctx.
+func (e *EventSocket) StopDtmfContext(ctx context.Context, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "stop_dtmf", "", uuid, islock, 0, false)
 }
 func (e *EventSocket) StartDtmfGenerate(uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_start_dtmf_generate
 	  >>> start_dtmf_generate()
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("start_dtmf_generate", "true", uuid, islock, 0, false)
+	return e.StartDtmfGenerateContext(context.Background(), uuid, islock)
+}
+
+// StartDtmfGenerateContext is like StartDtmfGenerate but honors the deadline and cancellation of ctx.
+func (e *EventSocket) StartDtmfGenerateContext(ctx context.Context, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "start_dtmf_generate", "true", uuid, islock, 0, false)
 }
 func (e *EventSocket) StopDtmfGenerate(uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_stop_dtmf_generate
 	  >>> stop_dtmf_generate()
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("stop_dtmf_generate", "", uuid, islock, 0, false)
+	return e.StopDtmfGenerateContext(context.Background(), uuid, islock)
+}
+
+// StopDtmfGenerateContext is like StopDtmfGenerate but honors the deadline and cancellation of ctx.
+func (e *EventSocket) StopDtmfGenerateContext(ctx context.Context, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "stop_dtmf_generate", "", uuid, islock, 0, false)
 }
 func (e *EventSocket) QueueDtmf(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_queue_dtmf
@@ -561,28 +1492,48 @@ func (e *EventSocket) QueueDtmf(args, uuid string, islock bool) (*Event, error)
 	  >>> queue_dtmf("0123456789")
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("queue_dtmf", args, uuid, islock, 0, false)
+	return e.QueueDtmfContext(context.Background(), args, uuid, islock)
+}
+
+// QueueDtmfContext is like QueueDtmf but honors the deadline and cancellation of ctx.
+func (e *EventSocket) QueueDtmfContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "queue_dtmf", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) FlushDtmf(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_flush_dtmf
 	  >>> flush_dtmf()
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("flush_dtmf", "", uuid, islock, 0, false)
+	return e.FlushDtmfContext(context.Background(), args, uuid, islock)
+}
+
+// FlushDtmfContext is like FlushDtmf but honors the deadline and cancellation of ctx.
+func (e *EventSocket) FlushDtmfContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "flush_dtmf", "", uuid, islock, 0, false)
 }
 func (e *EventSocket) PlayFsv(filename, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Mod_fsv
 	  >>> play_fsv("/tmp/video.fsv")
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("play_fsv", filename, uuid, islock, 0, false)
+	return e.PlayFsvContext(context.Background(), filename, uuid, islock)
+}
+
+// PlayFsvContext is like PlayFsv but honors the deadline and cancellation of ctx.
+func (e *EventSocket) PlayFsvContext(ctx context.Context, filename, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "play_fsv", filename, uuid, islock, 0, false)
 }
 func (e *EventSocket) RecordFsv(filename, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Mod_fsv
 	  >>> record_fsv("/tmp/video.fsv")
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("record_fsv", filename, uuid, islock, 0, false)
+	return e.RecordFsvContext(context.Background(), filename, uuid, islock)
+}
+
+// RecordFsvContext is like RecordFsv but honors the deadline and cancellation of ctx.
+func (e *EventSocket) RecordFsvContext(ctx context.Context, filename, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "record_fsv", filename, uuid, islock, 0, false)
 }
 func (e *EventSocket) Playback(filename, terminators, uuid string, islock bool, loops int) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Mod_playback
@@ -592,47 +1543,78 @@ func (e *EventSocket) Playback(filename, terminators, uuid string, islock bool,
 	  by pressing either '#' or '8'.
 	  For Inbound connection, uuid argument is mandatory.
 	*/
+	return e.PlaybackContext(context.Background(), filename, terminators, uuid, islock, loops)
+}
+
+// PlaybackContext is like Playback but honors the deadline and cancellation of ctx.
+func (e *EventSocket) PlaybackContext(ctx context.Context, filename, terminators, uuid string, islock bool, loops int) (*Event, error) {
 	if terminators == "" {
 		terminators = "none"
 	}
-	_, _ = e.Set(fmt.Sprintf("playback_terminators=%s", terminators), uuid, true)
-	return e.ProtocolSendMsg("playback", filename, uuid, islock, loops, false)
+	_, _ = e.SetContext(ctx, fmt.Sprintf("playback_terminators=%s", terminators), uuid, true)
+	return e.ProtocolSendMsgContext(ctx, "playback", filename, uuid, islock, loops, false)
 }
 func (e *EventSocket) Transfer(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_transfer
 	  >>> transfer("3222 XML public Eventault")
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("transfer", args, uuid, islock, 0, false)
+	return e.TransferContext(context.Background(), args, uuid, islock)
+}
+
+// TransferContext is like Transfer but honors the deadline and cancellation of ctx.
+func (e *EventSocket) TransferContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "transfer", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) AttXfer(url, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_att_xfer
 	  >>> att_xfer("user/1001")
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("att_xfer", url, uuid, islock, 0, false)
+	return e.AttXferContext(context.Background(), url, uuid, islock)
+}
+
+// AttXferContext is like AttXfer but honors the deadline and cancellation of ctx.
+func (e *EventSocket) AttXferContext(ctx context.Context, url, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "att_xfer", url, uuid, islock, 0, false)
 }
 func (e *EventSocket) EndlessPlayback(filename, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_endless_playback
 	  >>> endless_playback("/tmp/dump.gsm")
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("endless_playback", filename, uuid, islock, 0, false)
+	return e.EndlessPlaybackContext(context.Background(), filename, uuid, islock)
+}
+
+// EndlessPlaybackContext is like EndlessPlayback but honors the deadline and cancellation of ctx.
+func (e *EventSocket) EndlessPlaybackContext(ctx context.Context, filename, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "endless_playback", filename, uuid, islock, 0, false)
 }
 func (e *EventSocket) Record(fileName, timeLimit, silenceThresh, silenceHit, terminators, uuid string, loops int) (*Event, error) {
 	/*   Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_record
 
-	*/
+	 */
+	return e.RecordContext(context.Background(), fileName, timeLimit, silenceThresh, silenceHit, terminators, uuid, loops)
+}
+
+// RecordContext is like Record but honors the deadline and cancellation of ctx.
+func (e *EventSocket) RecordContext(ctx context.Context, fileName, timeLimit, silenceThresh, silenceHit, terminators, uuid string, loops int) (*Event, error) {
 	if terminators != "" {
-		e.Set(fmt.Sprintf("playback_terminators=%s", terminators), uuid, true)
+		e.SetContext(ctx, fmt.Sprintf("playback_terminators=%s", terminators), uuid, true)
 	}
 	args := fmt.Sprintf("%s %s %s %s", fileName, timeLimit, silenceThresh, silenceHit)
-	return e.ProtocolSendMsg("record", args, uuid, true, loops, false)
+	return e.ProtocolSendMsgContext(ctx, "record", args, uuid, true, loops, false)
 }
 func (e *EventSocket) PlayAndGetDigits(minDigits, maxDigits, maxTries, timeout int, terminators, invalidFile, digitVarName, validDigits, digitTimeout, uuid string,
 	playBeep bool, soundFiles []string) (*Event, error) {
 	/*   Please refer to http://wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_play_and_get_digits
 	 */
+	return e.PlayAndGetDigitsContext(context.Background(), minDigits, maxDigits, maxTries, timeout, terminators, invalidFile, digitVarName, validDigits, digitTimeout, uuid, playBeep, soundFiles)
+}
+
+// PlayAndGetDigitsContext is like PlayAndGetDigits but honors the deadline and cancellation of ctx.
+func (e *EventSocket) PlayAndGetDigitsContext(ctx context.Context, minDigits, maxDigits, maxTries, timeout int, terminators, invalidFile, digitVarName, validDigits, digitTimeout, uuid string,
+	playBeep bool, soundFiles []string) (*Event, error) {
 	playStr := ""
 	beep := "tone_stream://%(300,200,700)"
 	if len(soundFiles) == 0 {
@@ -642,7 +1624,7 @@ func (e *EventSocket) PlayAndGetDigits(minDigits, maxDigits, maxTries, timeout i
 			playStr = "silence_stream://10"
 		}
 	} else {
-		e.Set("playback_delimiter=!", uuid, true)
+		e.SetContext(ctx, "playback_delimiter=!", uuid, true)
 		playStr = "file_string://silence_stream://1"
 		for _, soundFile := range soundFiles {
 			playStr += fmt.Sprintf("%s!%s", playStr, soundFile)
@@ -655,7 +1637,7 @@ func (e *EventSocket) PlayAndGetDigits(minDigits, maxDigits, maxTries, timeout i
 		invalidFile = "silence_stream://150"
 	}
 	if digitTimeout == "" {
-		digitTimeout = string(timeout)
+		digitTimeout = strconv.Itoa(timeout)
 	}
 	reg := ""
 	if validDigits == "" {
@@ -668,20 +1650,30 @@ func (e *EventSocket) PlayAndGetDigits(minDigits, maxDigits, maxTries, timeout i
 		}
 	}
 	regexp := fmt.Sprintf("(%s)", reg)
-	args := fmt.Sprintf("%s %s %s %s '%s' %s %s %s %s %s", minDigits, maxDigits, maxTries, timeout, terminators, playStr, invalidFile, digitVarName, regexp, digitTimeout)
-	return e.ProtocolSendMsg("play_and_get_digits", args, uuid, true, 0, false)
+	args := fmt.Sprintf("%d %d %d %d '%s' %s %s %s %s %s", minDigits, maxDigits, maxTries, timeout, terminators, playStr, invalidFile, digitVarName, regexp, digitTimeout)
+	return e.ProtocolSendMsgContext(ctx, "play_and_get_digits", args, uuid, true, 0, false)
 }
 func (e *EventSocket) PreAnswer() (*Event, error) {
 	/*  Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_pre_answer
 
 	    Can only be used for outbound connection
 	*/
-	return e.ProtocolSendMsg("pre_answer", "", "", true, 0, false)
+	return e.PreAnswerContext(context.Background())
+}
+
+// PreAnswerContext is like PreAnswer but honors the deadline and cancellation of ctx.
+func (e *EventSocket) PreAnswerContext(ctx context.Context) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "pre_answer", "", "", true, 0, false)
 }
 func (e *EventSocket) Conference(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Mod_conference
 	  >>> conference(args) For Inbound connection, uuid argument is mandatory.*/
-	return e.ProtocolSendMsg("conference", args, uuid, islock, 0, false)
+	return e.ConferenceContext(context.Background(), args, uuid, islock)
+}
+
+// ConferenceContext is like Conference but honors the deadline and cancellation of ctx.
+func (e *EventSocket) ConferenceContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "conference", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) Speak(text, uuid string, islock bool, loop int) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/TTS
@@ -690,18 +1682,33 @@ func (e *EventSocket) Speak(text, uuid string, islock bool, loop int) (*Event, e
 	  >>> speak(text)
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("speak", text, uuid, islock, loop, false)
+	return e.SpeakContext(context.Background(), text, uuid, islock, loop)
+}
+
+// SpeakContext is like Speak but honors the deadline and cancellation of ctx.
+func (e *EventSocket) SpeakContext(ctx context.Context, text, uuid string, islock bool, loop int) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "speak", text, uuid, islock, loop, false)
 }
 func (e *EventSocket) Hupall(args string) (*Event, error) {
 	//"Please refer to http;//wiki.freeswitch.org/wiki/Mod_commands#hupall"
-	return e.ProtocolSendMsg("hupall", args, "", true, 0, false)
+	return e.HupallContext(context.Background(), args)
+}
+
+// HupallContext is like Hupall but honors the deadline and cancellation of ctx.
+func (e *EventSocket) HupallContext(ctx context.Context, args string) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "hupall", args, "", true, 0, false)
 }
 func (e *EventSocket) Say(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_say
 	  >>> say(en number pronounced 12345)
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("say", args, uuid, islock, 0, false)
+	return e.SayContext(context.Background(), args, uuid, islock)
+}
+
+// SayContext is like Say but honors the deadline and cancellation of ctx.
+func (e *EventSocket) SayContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "say", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) SchedHangup(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_sched_hangup
@@ -710,12 +1717,22 @@ func (e *EventSocket) SchedHangup(args, uuid string, islock bool) (*Event, error
 
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("sched_hangup", args, uuid, islock, 0, false)
+	return e.SchedHangupContext(context.Background(), args, uuid, islock)
+}
+
+// SchedHangupContext is like SchedHangup but honors the deadline and cancellation of ctx.
+func (e *EventSocket) SchedHangupContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "sched_hangup", args, uuid, islock, 0, false)
 }
 func (e *EventSocket) SchedTransfer(args, uuid string, islock bool) (*Event, error) {
 	/*"Please refer to http;//wiki.freeswitch.org/wiki/Misc._Dialplan_Tools_sched_transfer
 	  >>> sched_transfer("+60 9999 XML public default")
 	  For Inbound connection, uuid argument is mandatory.
 	*/
-	return e.ProtocolSendMsg("sched_transfer", args, uuid, islock, 0, false)
+	return e.SchedTransferContext(context.Background(), args, uuid, islock)
+}
+
+// SchedTransferContext is like SchedTransfer but honors the deadline and cancellation of ctx.
+func (e *EventSocket) SchedTransferContext(ctx context.Context, args, uuid string, islock bool) (*Event, error) {
+	return e.ProtocolSendMsgContext(ctx, "sched_transfer", args, uuid, islock, 0, false)
 }
@@ -0,0 +1,512 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...interface{}) {}
+
+// testServer is the FreeSWITCH end of a socket under test.
+type testServer struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newTestSocket returns a socket with its read loop running, connected
+// through a pipe to the returned server.
+func newTestSocket(t *testing.T) (*EventSocket, *testServer) {
+	client, server := net.Pipe()
+	e := NewEventSocket(client, nil)
+	e.SetLogger(nopLogger{})
+	go e.readLoop()
+	t.Cleanup(func() {
+		server.Close()
+		<-e.done()
+	})
+	return e, &testServer{conn: server, reader: bufio.NewReader(server)}
+}
+
+// commandLines reads the next command and returns its lines.
+func (srv *testServer) commandLines() ([]string, error) {
+	var lines []string
+	for {
+		line, err := srv.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\n")
+		if line == "" {
+			if lines == nil {
+				continue
+			}
+			return lines, nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// command reads the next command and returns its first line.
+func (srv *testServer) command() (string, error) {
+	lines, err := srv.commandLines()
+	if err != nil {
+		return "", err
+	}
+	return lines[0], nil
+}
+
+func (srv *testServer) mustCommand(t *testing.T) string {
+	t.Helper()
+	cmd, err := srv.command()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+// apiReply sends an api/response with body.
+func (srv *testServer) apiReply(t *testing.T, body string) {
+	t.Helper()
+	if _, err := fmt.Fprintf(srv.conn, "Content-Type: api/response\nContent-Length: %d\n\n%s", len(body), body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPipelinedReplies(t *testing.T) {
+	const depth, calls = 4, 20
+	e, srv := newTestSocket(t)
+	e.SetPipelineDepth(depth)
+
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ev, err := e.APICommandContext(context.Background(), fmt.Sprintf("echo %d", i))
+			if err != nil {
+				t.Errorf("echo %d: %s", i, err)
+				return
+			}
+			if want := fmt.Sprintf("api echo %d", i); ev.Body != want {
+				t.Errorf("echo %d got reply %q", i, ev.Body)
+			}
+		}(i)
+	}
+	for n := 0; n < calls; n += depth {
+		cmds := make([]string, depth)
+		for i := range cmds {
+			cmds[i] = srv.mustCommand(t)
+		}
+		// No more than depth commands may be in flight.
+		srv.conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		if cmd, err := srv.command(); err == nil {
+			t.Fatalf("command %q written past the pipeline depth", cmd)
+		}
+		srv.conn.SetReadDeadline(time.Time{})
+		for _, cmd := range cmds {
+			srv.apiReply(t, cmd)
+		}
+	}
+	wg.Wait()
+}
+
+func TestCancelledCommandReplyDiscarded(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := e.APICommandContext(ctx, "first")
+		first <- err
+	}()
+	srv.mustCommand(t)
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("cancelled command returned %v", err)
+	}
+
+	second := make(chan *Event, 1)
+	go func() {
+		ev, err := e.APICommandContext(context.Background(), "second")
+		if err != nil {
+			t.Error(err)
+		}
+		second <- ev
+	}()
+	// The late reply to the first command must not reach the second.
+	srv.apiReply(t, "late")
+	if cmd := srv.mustCommand(t); cmd != "api second" {
+		t.Fatalf("got command %q", cmd)
+	}
+	srv.apiReply(t, "second")
+	if ev := <-second; ev == nil || ev.Body != "second" {
+		t.Fatalf("second command got %v", ev)
+	}
+}
+
+func TestCommandNotWrittenAfterContextDone(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := e.APICommandContext(ctx, "never"); err != context.Canceled {
+		t.Fatalf("got %v", err)
+	}
+	go e.APICommandContext(context.Background(), "status")
+	if cmd := srv.mustCommand(t); cmd != "api status" {
+		t.Fatalf("got command %q", cmd)
+	}
+	srv.apiReply(t, "ok")
+}
+
+func TestPendingCommandsFailOnDisconnect(t *testing.T) {
+	e, srv := newTestSocket(t)
+	e.SetPipelineDepth(2)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			_, err := e.APICommandContext(context.Background(), fmt.Sprintf("cmd %d", i))
+			errs <- err
+		}(i)
+	}
+	srv.mustCommand(t)
+	srv.mustCommand(t)
+	srv.conn.Close()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != errDisconnected {
+			t.Errorf("pending command returned %v, want %v", err, errDisconnected)
+		}
+	}
+	<-e.done()
+	if _, err := e.APICommandContext(context.Background(), "after"); err != errNotConnected {
+		t.Errorf("command after disconnect returned %v, want %v", err, errNotConnected)
+	}
+}
+
+func TestJobEventBeforeReply(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	jobs := make(chan *Job, 1)
+	go func() {
+		job, err := e.BgAPICommandContext(context.Background(), "status")
+		if err != nil {
+			t.Error(err)
+		}
+		jobs <- job
+	}()
+	if cmd := srv.mustCommand(t); cmd != "event plain BACKGROUND_JOB" {
+		t.Fatalf("got command %q", cmd)
+	}
+	io.WriteString(srv.conn, "Content-Type: command/reply\nReply-Text: +OK event listener enabled plain\n\n")
+	lines, err := srv.commandLines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0] != "bgapi status" || !strings.HasPrefix(lines[1], "Job-UUID: ") {
+		t.Fatalf("got command %q", lines)
+	}
+	uuid := strings.TrimPrefix(lines[1], "Job-UUID: ")
+	// FreeSWITCH may send the event before the reply to bgapi.
+	ev := fmt.Sprintf("Event-Name: BACKGROUND_JOB\nJob-UUID: %s\nContent-Length: 8\n\n+OK done", uuid)
+	fmt.Fprintf(srv.conn, "Content-Type: text/event-plain\nContent-Length: %d\n\n%s", len(ev), ev)
+	fmt.Fprintf(srv.conn, "Content-Type: command/reply\nReply-Text: +OK Job-UUID: %s\nJob-UUID: %s\n\n", uuid, uuid)
+	job := <-jobs
+	if job == nil {
+		t.FailNow()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := job.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "done" {
+		t.Errorf("job result %q", res)
+	}
+}
+
+// sendEvent sends a text/event-plain frame with the given headers.
+func (srv *testServer) sendEvent(t *testing.T, headers string) {
+	t.Helper()
+	body := headers + "\n"
+	if _, err := fmt.Fprintf(srv.conn, "Content-Type: text/event-plain\nContent-Length: %d\n\n%s", len(body), body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepliesReadWhileEventsUnread(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	replies := make(chan error, 1)
+	go func() {
+		_, err := e.ProtocolSendContext(context.Background(), "filter", "Event-Name HEARTBEAT")
+		replies <- err
+	}()
+	srv.mustCommand(t)
+	// Nobody calls readEvent, e.g. while Start is reconnecting.
+	srv.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 4*eventsBuffer; i++ {
+		srv.sendEvent(t, fmt.Sprintf("Event-Name: HEARTBEAT\nEvent-Sequence: %d\n", i))
+	}
+	io.WriteString(srv.conn, "Content-Type: command/reply\nReply-Text: +OK filter added\n\n")
+	select {
+	case err := <-replies:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reply not read behind unread events")
+	}
+	for i := 0; i < 4*eventsBuffer; i++ {
+		ev, err := e.readEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ev.GetHeader("Event-Sequence", ""); got != fmt.Sprint(i) {
+			t.Fatalf("event %d has Event-Sequence %s", i, got)
+		}
+	}
+}
+
+// serveJobs answers the subscribe and n bgapi commands, completing each job
+// right after its reply.
+func (srv *testServer) serveJobs(t *testing.T, n int) {
+	if cmd, err := srv.command(); err != nil || cmd != "event plain BACKGROUND_JOB" {
+		t.Errorf("got command %q, %v", cmd, err)
+		return
+	}
+	io.WriteString(srv.conn, "Content-Type: command/reply\nReply-Text: +OK event listener enabled plain\n\n")
+	for i := 0; i < n; i++ {
+		lines, err := srv.commandLines()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		uuid := strings.TrimPrefix(lines[1], "Job-UUID: ")
+		fmt.Fprintf(srv.conn, "Content-Type: command/reply\nReply-Text: +OK Job-UUID: %s\nJob-UUID: %s\n\n", uuid, uuid)
+		ev := fmt.Sprintf("Event-Name: BACKGROUND_JOB\nJob-UUID: %s\nContent-Length: 5\n\n+OK %d", uuid, i%10)
+		fmt.Fprintf(srv.conn, "Content-Type: text/event-plain\nContent-Length: %d\n\n%s", len(ev), ev)
+	}
+}
+
+func TestJobsWithoutEventReader(t *testing.T) {
+	const jobs = 4 * eventsBuffer
+	e, srv := newTestSocket(t)
+	go srv.serveJobs(t, jobs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < jobs; i++ {
+		job, err := e.BgAPICommandContext(ctx, "status")
+		if err != nil {
+			t.Fatalf("job %d: %s", i, err)
+		}
+		res, err := job.Wait(ctx)
+		if err != nil {
+			t.Fatalf("job %d: %s", i, err)
+		}
+		if want := fmt.Sprint(i % 10); res != want {
+			t.Errorf("job %d result %q, want %q", i, res, want)
+		}
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if len(e.queued) != 0 {
+		t.Errorf("%d job events queued for readEvent", len(e.queued))
+	}
+}
+
+func TestSendEventFraming(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	ids := make(chan string, 1)
+	go func() {
+		id, err := e.SendEvent(context.Background(), &Event{
+			Header: map[string]string{
+				"Event-Subclass": "my::event",
+				"Event-UUID":     "ignored",
+				"Content-Length": "99",
+				"Caller":         "a b:c",
+			},
+			Body: "line 1\nline 2",
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		ids <- id
+	}()
+	lines, err := srv.commandLines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"sendevent CUSTOM", "Caller: a b:c", "Event-Subclass: my::event", "Content-Length: 13"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("got command %q, want %q", lines, want)
+	}
+	body := make([]byte, 13)
+	if _, err := io.ReadFull(srv.reader, body); err != nil || string(body) != "line 1\nline 2" {
+		t.Fatalf("got body %q, %v", body, err)
+	}
+	io.WriteString(srv.conn, "Content-Type: command/reply\nReply-Text: +OK 2f0e1e1c-0000-4000-8000-000000000000\n\n")
+	if id := <-ids; id != "2f0e1e1c-0000-4000-8000-000000000000" {
+		t.Errorf("got Event-UUID %q", id)
+	}
+
+	// Without a body the headers end the command.
+	go e.SendEvent(context.Background(), &Event{Header: map[string]string{"Event-Name": "HEARTBEAT"}})
+	if lines, err := srv.commandLines(); err != nil || !reflect.DeepEqual(lines, []string{"sendevent HEARTBEAT"}) {
+		t.Fatalf("got command %q, %v", lines, err)
+	}
+	io.WriteString(srv.conn, "Content-Type: command/reply\nReply-Text: +OK\n\n")
+}
+
+func TestSendEventRejected(t *testing.T) {
+	e, _ := newTestSocket(t)
+
+	_, err := e.SendEvent(context.Background(), &Event{Header: map[string]string{
+		"Event-Name": "CUSTOM",
+		"Injected":   "x\nsendevent HEARTBEAT",
+	}})
+	if herr, ok := err.(*HeaderError); !ok || herr.Header != "Injected" || herr.Err != errLineBreak {
+		t.Errorf("line break: got %v", err)
+	}
+	if _, err := e.SendEvent(context.Background(), &Event{Header: map[string]string{"Caller": "a"}}); err != errNoEventName {
+		t.Errorf("no name: got %v", err)
+	}
+}
+
+func TestSlowLogHandler(t *testing.T) {
+	e, srv := newTestSocket(t)
+	release := make(chan struct{})
+	defer close(release)
+	e.OnLog(func(*LogLine) { <-release })
+
+	replies := make(chan error, 1)
+	go func() {
+		_, err := e.ProtocolSendContext(context.Background(), "log", "7")
+		replies <- err
+	}()
+	srv.mustCommand(t)
+	srv.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 4*eventsBuffer; i++ {
+		if _, err := io.WriteString(srv.conn, "Content-Type: log/data\nContent-Length: 5\nLog-Level: 7\n\nhello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	io.WriteString(srv.conn, "Content-Type: command/reply\nReply-Text: +OK log level 7 [7]\n\n")
+	select {
+	case err := <-replies:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reply held up by a slow log handler")
+	}
+}
+
+type executeResult struct {
+	ev  *Event
+	err error
+}
+
+// startExecute runs playback with SetWaitForCompletion, answers the
+// subscribe to CHANNEL_EXECUTE_COMPLETE and returns the Event-UUID sent
+// with the sendmsg, which is left unanswered.
+func startExecute(ctx context.Context, t *testing.T, e *EventSocket, srv *testServer) (string, chan executeResult) {
+	t.Helper()
+	e.SetWaitForCompletion(true)
+	results := make(chan executeResult, 1)
+	go func() {
+		ev, err := e.ProtocolSendMsgContext(ctx, "playback", "", "call-uuid", false, 0, false)
+		results <- executeResult{ev, err}
+	}()
+	if cmd := srv.mustCommand(t); cmd != "event plain CHANNEL_EXECUTE_COMPLETE" {
+		t.Fatalf("got command %q", cmd)
+	}
+	srv.replyOK(t)
+	lines, err := srv.commandLines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines[0] != "sendmsg call-uuid" {
+		t.Fatalf("got command %q", lines)
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "event-uuid: ") {
+			return strings.TrimPrefix(line, "event-uuid: "), results
+		}
+	}
+	t.Fatalf("no event-uuid in %q", lines)
+	return "", nil
+}
+
+func TestWaitForCompletion(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	uuid, results := startExecute(context.Background(), t, e, srv)
+	srv.replyOK(t)
+	srv.sendEvent(t, "Event-Name: CHANNEL_EXECUTE_COMPLETE\nApplication-UUID: other\nApplication-Response: other\n")
+	srv.sendEvent(t, fmt.Sprintf("Event-Name: CHANNEL_EXECUTE_COMPLETE\nApplication-UUID: %s\nApplication-Response: FILE PLAYED\n", uuid))
+	res := <-results
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if got := res.ev.GetHeader("Application-Response", ""); got != "FILE PLAYED" {
+		t.Errorf("got Application-Response %q", got)
+	}
+}
+
+func TestExecuteCompleteBeforeReply(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	uuid, results := startExecute(context.Background(), t, e, srv)
+	// A short application may complete before FreeSWITCH replies to the
+	// sendmsg.
+	srv.sendEvent(t, fmt.Sprintf("Event-Name: CHANNEL_EXECUTE_COMPLETE\nApplication-UUID: %s\nApplication-Response: FILE PLAYED\n", uuid))
+	srv.replyOK(t)
+	select {
+	case res := <-results:
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		if got := res.ev.GetHeader("Application-UUID", ""); got != uuid {
+			t.Errorf("got Application-UUID %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("execute complete before the reply was lost")
+	}
+}
+
+func TestCancelledExecuteForgotten(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, results := startExecute(ctx, t, e, srv)
+	srv.replyOK(t)
+	cancel()
+	if res := <-results; res.err != context.Canceled {
+		t.Fatalf("got error %v", res.err)
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if len(e.executes) != 0 {
+		t.Errorf("executes left: %v", e.executes)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"context"
+	"strings"
+)
+
+// EventFilter is an event filter active on the server: only events whose
+// Header is Value, for any of the filters, are sent.
+type EventFilter struct {
+	Header string
+	Value  string
+}
+
+func (f EventFilter) String() string {
+	return f.Header + " " + f.Value
+}
+
+// parseFilter parses the "Header value" argument of a filter command.
+func parseFilter(args string) EventFilter {
+	fields := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	f := EventFilter{Header: fields[0]}
+	if len(fields) == 2 {
+		f.Value = strings.TrimSpace(fields[1])
+	}
+	return f
+}
+
+// matches reports whether a `filter delete` with args removes f: args is
+// "all", a header or a header and value. Header names are not case
+// sensitive.
+func (f EventFilter) matches(args string) bool {
+	del := parseFilter(args)
+	switch {
+	case del.Value == "" && strings.EqualFold(del.Header, "all"):
+		return true
+	case !strings.EqualFold(f.Header, del.Header):
+		return false
+	}
+	return del.Value == "" || del.Value == f.Value
+}
+
+// Filters returns the filters active on the socket, in the order they were
+// added. They are applied again after a reconnect.
+func (e *EventSocket) Filters() []EventFilter {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return append([]EventFilter(nil), e.filters...)
+}
+
+// hasFilter reports whether the filter f is active.
+func (e *EventSocket) hasFilter(f EventFilter) bool {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	for _, active := range e.filters {
+		if strings.EqualFold(active.Header, f.Header) && active.Value == f.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// keepEvent adds an Event-Name filter for name if any filter is active, so
+// the events the socket waits for itself are not filtered out.
+func (e *EventSocket) keepEvent(ctx context.Context, name string) error {
+	e.mtx.Lock()
+	active := len(e.filters) > 0
+	e.mtx.Unlock()
+	if !active {
+		return nil
+	}
+	ev, err := e.FilterContext(ctx, "Event-Name "+name)
+	if err != nil {
+		return err
+	}
+	if !ev.IsReplyTextSuccess() {
+		return errFilterFailed
+	}
+	return nil
+}
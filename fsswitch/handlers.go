@@ -0,0 +1,208 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// eventHandler wraps a handler function, so a handler can be told apart
+// from the others registered for the same event when it is removed.
+type eventHandler struct {
+	fn func(*Event)
+}
+
+// On adds fn to the handlers of eventName and returns a function removing
+// it again. CUSTOM events are named with their subclass, e.g.
+// "CUSTOM sofia::register"; handlers for plain "CUSTOM" receive every
+// CUSTOM event the socket is subscribed to, and handlers for ALL every
+// event. Adding the first handler for an event subscribes to it and
+// removing the last one unsubscribes with nixevent, unless the socket was
+// subscribed to it, or to ALL, beforehand. Handlers are kept across
+// reconnects.
+//
+// On, Off and the returned function are safe for concurrent use. A failed
+// subscribe is logged; use OnContext to get the error.
+func (e *EventSocket) On(eventName string, fn func(*Event)) (unsubscribe func()) {
+	unsubscribe, err := e.OnContext(context.Background(), eventName, fn)
+	if err != nil {
+		e.logger.Printf("Error subscribing to %s: %s", eventName, err)
+		return func() {}
+	}
+	return unsubscribe
+}
+
+// OnContext is like On but honors the deadline and cancellation of ctx.
+// If subscribing to eventName fails, fn is not added and the error is
+// returned. Calls adding handlers for an event that is being subscribed
+// to wait for the subscribe and fail along with it.
+func (e *EventSocket) OnContext(ctx context.Context, eventName string, fn func(*Event)) (unsubscribe func(), err error) {
+	h := &eventHandler{fn: fn}
+	eventName = eventKey(splitEventKey(eventName))
+	e.handlerMtx.Lock()
+	e.mtx.Lock()
+	handlers := e.eventHandlers[eventName]
+	// Copy on write: the dispatcher reads the slice without the lock.
+	e.eventHandlers[eventName] = append(handlers[:len(handlers):len(handlers)], h)
+	pending := e.subscribing[eventName]
+	subscribe := len(handlers) == 0 && !e.events["ALL"] && !e.events[subscribedName(eventName)]
+	if subscribe {
+		e.handlerEvents[eventName] = true
+		pending = &pendingSubscribe{done: make(chan struct{})}
+		e.subscribing[eventName] = pending
+	}
+	if pending != nil {
+		pending.handlers = append(pending.handlers, h)
+	}
+	e.mtx.Unlock()
+	e.handlerMtx.Unlock()
+	remove := func() {
+		if e.removeHandlers(eventName, h) {
+			e.unsubscribeHandled(eventName)
+		}
+	}
+	switch {
+	case subscribe:
+		err = e.subscribeHandled(ctx, eventName)
+		e.finishSubscribe(eventName, pending, err)
+	case pending != nil:
+		select {
+		case <-pending.done:
+			err = pending.err
+		case <-ctx.Done():
+			remove()
+			return nil, contextError("event", ctx.Err())
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(remove) }, nil
+}
+
+// pendingSubscribe is a subscribe for the handlers of an event in
+// progress.
+type pendingSubscribe struct {
+	handlers []*eventHandler // Added while subscribing
+	done     chan struct{}   // Closed once err is set
+	err      error
+}
+
+// finishSubscribe records the outcome of the subscribe p. If it failed,
+// every handler added while subscribing is removed again; nothing was
+// subscribed to, so there is nothing to undo.
+func (e *EventSocket) finishSubscribe(eventName string, p *pendingSubscribe, err error) {
+	e.handlerMtx.Lock()
+	e.mtx.Lock()
+	delete(e.subscribing, eventName)
+	if err != nil {
+		var kept []*eventHandler
+		for _, h := range e.eventHandlers[eventName] {
+			added := false
+			for _, other := range p.handlers {
+				added = added || h == other
+			}
+			if !added {
+				kept = append(kept, h)
+			}
+		}
+		if len(kept) > 0 {
+			e.eventHandlers[eventName] = kept
+		} else {
+			delete(e.eventHandlers, eventName)
+			delete(e.handlerEvents, eventName)
+		}
+	}
+	p.err = err
+	e.mtx.Unlock()
+	e.handlerMtx.Unlock()
+	close(p.done)
+}
+
+// Off removes every handler of eventName, unsubscribing from it as On
+// describes.
+func (e *EventSocket) Off(eventName string) {
+	eventName = eventKey(splitEventKey(eventName))
+	if e.removeHandlers(eventName, nil) {
+		e.unsubscribeHandled(eventName)
+	}
+}
+
+// removeHandlers removes h, or all handlers if h is nil, from the handlers
+// of eventName. It reports whether the last handler is gone and the event
+// was subscribed to for the handlers, so it should be unsubscribed from.
+func (e *EventSocket) removeHandlers(eventName string, h *eventHandler) bool {
+	e.handlerMtx.Lock()
+	defer e.handlerMtx.Unlock()
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	var handlers []*eventHandler
+	if h != nil {
+		for _, other := range e.eventHandlers[eventName] {
+			if other != h {
+				handlers = append(handlers, other)
+			}
+		}
+	}
+	if len(handlers) > 0 {
+		e.eventHandlers[eventName] = handlers
+		return false
+	}
+	delete(e.eventHandlers, eventName)
+	unsubscribe := e.handlerEvents[eventName]
+	delete(e.handlerEvents, eventName)
+	return unsubscribe
+}
+
+// subscribeHandled subscribes to an event a handler was added for. While
+// disconnected the event is only recorded, to be subscribed to on
+// reconnect.
+func (e *EventSocket) subscribeHandled(ctx context.Context, eventName string) error {
+	e.mtx.Lock()
+	format := e.format
+	e.mtx.Unlock()
+	ev, err := e.subscribe(ctx, format, eventName)
+	switch {
+	case err == errNotConnected || err == errDisconnected:
+		e.mtx.Lock()
+		e.events[subscribedName(eventName)] = true
+		e.mtx.Unlock()
+		return nil
+	case err != nil:
+		return err
+	case !ev.IsReplyTextSuccess():
+		return errors.New(ev.GetReplyText())
+	}
+	return nil
+}
+
+// unsubscribeHandled unsubscribes from an event whose last handler was
+// removed.
+func (e *EventSocket) unsubscribeHandled(eventName string) {
+	ev, err := e.NixEventContext(context.Background(), eventName)
+	switch {
+	case err == errNotConnected || err == errDisconnected:
+	case err != nil:
+		e.logger.Printf("Error unsubscribing from %s: %s", eventName, err)
+	case !ev.IsReplyTextSuccess():
+		e.logger.Printf("Error unsubscribing from %s: %s", eventName, ev.GetReplyText())
+	}
+	// Don't subscribe to it again on reconnect, whatever happened.
+	e.mtx.Lock()
+	delete(e.events, subscribedName(eventName))
+	format, names := e.format, eventList(e.events)
+	e.mtx.Unlock()
+	if eventName == "ALL" && names != "" && err == nil {
+		// nixevent ALL drops every subscription; restore the others.
+		if _, err := e.subscribe(context.Background(), format, names); err != nil {
+			e.logger.Printf("Error subscribing to %s: %s", names, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// replyOK answers a command with +OK.
+func (srv *testServer) replyOK(t *testing.T) {
+	t.Helper()
+	if _, err := io.WriteString(srv.conn, "Content-Type: command/reply\nReply-Text: +OK\n\n"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnDoesNotBlockDuringSubscribe(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	subscribed := make(chan error, 1)
+	go func() {
+		_, err := e.OnContext(context.Background(), "HEARTBEAT", func(*Event) {})
+		subscribed <- err
+	}()
+	srv.mustCommand(t)
+	srv.replyOK(t)
+	if err := <-subscribed; err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	first := make(chan error, 1)
+	go func() {
+		_, err := e.OnContext(ctx, "CHANNEL_ANSWER", func(*Event) {})
+		first <- err
+	}()
+	// Leave the subscribe unanswered while a handler is added for an
+	// event already subscribed to.
+	if cmd := srv.mustCommand(t); cmd != "event plain CHANNEL_ANSWER" {
+		t.Fatalf("got command %q", cmd)
+	}
+	added := make(chan struct{})
+	go func() {
+		e.On("HEARTBEAT", func(*Event) {})
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("On blocked behind a pending subscribe")
+	}
+	// A handler for the event being subscribed to waits for the subscribe.
+	second := make(chan error, 1)
+	go func() {
+		_, err := e.OnContext(ctx, "CHANNEL_ANSWER", func(*Event) {})
+		second <- err
+	}()
+	select {
+	case err := <-second:
+		t.Fatalf("second handler returned %v before the subscribe completed", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	srv.replyOK(t)
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-second; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentOnSubscribeError(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := e.OnContext(context.Background(), "CHANNEL_ANSWER", func(*Event) {})
+		errs <- err
+	}()
+	srv.mustCommand(t)
+	go func() {
+		_, err := e.OnContext(context.Background(), "CHANNEL_ANSWER", func(*Event) {})
+		errs <- err
+	}()
+	// Let the second call join the pending subscribe.
+	for {
+		e.mtx.Lock()
+		n := len(e.eventHandlers["CHANNEL_ANSWER"])
+		e.mtx.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	io.WriteString(srv.conn, "Content-Type: command/reply\nReply-Text: -ERR denied\n\n")
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Error("handler added although the subscribe failed")
+		}
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if n := len(e.eventHandlers["CHANNEL_ANSWER"]); n != 0 {
+		t.Errorf("%d handlers left after the failed subscribe", n)
+	}
+	if e.handlerEvents["CHANNEL_ANSWER"] {
+		t.Error("event still marked as subscribed for handlers")
+	}
+}
+
+func TestOnContextSubscribeError(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := e.OnContext(context.Background(), "CUSTOM my::event", func(*Event) {})
+		errs <- err
+	}()
+	srv.mustCommand(t)
+	io.WriteString(srv.conn, "Content-Type: command/reply\nReply-Text: -ERR no keywords supplied\n\n")
+	if err := <-errs; err == nil || err.Error() != "-ERR no keywords supplied" {
+		t.Fatalf("got error %v", err)
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if n := len(e.eventHandlers["CUSTOM my::event"]); n != 0 {
+		t.Errorf("%d handlers left after the failed subscribe", n)
+	}
+	if e.handlerEvents["CUSTOM my::event"] {
+		t.Error("event still marked as subscribed for handlers")
+	}
+}
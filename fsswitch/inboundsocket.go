@@ -8,113 +8,415 @@ Provides FreeSWITCH socket communication.
 package fsswitch
 
 import (
+	"context"
 	"errors"
+	"math"
+	"math/rand"
 	"net"
+	"sync"
 	"time"
 )
 
 var errMissingAuthRequest = errors.New("Missing auth request")
-var errInvalidPassword = errors.New("Invalid password")
 var errInvalidCommand = errors.New("Invalid command contains \\r or \\n")
 var errFilterFailed = errors.New("Event filter failed")
+var errClosed = errors.New("Socket closed")
+
+// replayTimeout bounds replaying the subscriptions after a reconnect.
+const replayTimeout = 30 * time.Second
+
+// ConnState is the connection state of an InboundSocket.
+type ConnState int
+
+const (
+	StateConnecting     ConnState = iota // Dialing FreeSWITCH
+	StateAuthenticating                  // Connected, waiting for auth
+	StateSubscribed                      // Authenticated and subscribed, events flowing
+	StateDisconnected                    // Connection lost, about to reconnect
+	StateClosed                          // Closed with Close, for good
+)
+
+var connStateNames = [...]string{"connecting", "authenticating", "subscribed", "disconnected", "closed"}
+
+func (s ConnState) String() string {
+	if s < 0 || int(s) >= len(connStateNames) {
+		return "unknown"
+	}
+	return connStateNames[s]
+}
+
+// ReconnectPolicy controls how an InboundSocket retries connecting to
+// FreeSWITCH. The delay starts at InitialBackoff and is multiplied by
+// Multiplier after every failed attempt, up to MaxBackoff. Jitter randomizes
+// each delay by up to that fraction in either direction. Zero MaxAttempts
+// and MaxElapsed retry forever.
+type ReconnectPolicy struct {
+	InitialBackoff time.Duration // 500ms if zero
+	MaxBackoff     time.Duration
+	Multiplier     float64 // 2 if zero
+	Jitter         float64 // Between 0 and 1
+	MaxElapsed     time.Duration
+	MaxAttempts    int
+}
+
+// DefaultReconnectPolicy retries forever, backing off from 500ms to 30s.
+var DefaultReconnectPolicy = ReconnectPolicy{
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// delay returns how long to wait after the given (1-based) failed attempt,
+// or false if the policy gives up.
+func (p ReconnectPolicy) delay(attempt int, elapsed time.Duration) (time.Duration, bool) {
+	if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	if p.MaxElapsed > 0 && elapsed >= p.MaxElapsed {
+		return 0, false
+	}
+	mult := p.Multiplier
+	if mult <= 0 {
+		mult = 2
+	}
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = DefaultReconnectPolicy.InitialBackoff
+	}
+	d := float64(initial) * math.Pow(mult, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d), true
+}
 
 type InboundSocket struct {
 	fsaddress, fspassword string
-	reconnects            int
+	user, domain          string // Set for userauth
+	policy                ReconnectPolicy
 	eventHandlers         map[string][]func(*Event)
 	*EventSocket
-	isEventJson bool
+	format   string   // Event format: plain, json or xml
+	events   []string // Extra events to subscribe to
+	filters  []string // Filters applied on connect, as "Header value"
+	dialer   net.Dialer
+	logger   Logger
+	dispatch DispatchMode
+
+	stateMtx     sync.Mutex // Protects state, the hooks and EventSocket
+	state        ConnState
+	closing      chan struct{} // Closed by Close
+	onConnect    func()
+	onDisconnect func(error)
+	onReconnect  func(int)
 }
 
-//Dial(fsaddress, fspassword string, reconnects uint8, eventHandlers map[string][]func(*Event)) (*EventSocket, error) {
-func (self *InboundSocket) connect() error {
+// SetReconnectPolicy replaces the policy used by Start to reconnect.
+func (self *InboundSocket) SetReconnectPolicy(policy ReconnectPolicy) {
+	self.policy = policy
+}
 
-	var err error
-	for i := 0; i < self.reconnects; i++ {
-		c, err := net.Dial("tcp", self.fsaddress)
-		if err == nil {
-			self.EventSocket = NewEventSocket(c, self.eventHandlers)
-			go self.readLoop()
-			var ev *Event
+// State returns the current connection state.
+func (self *InboundSocket) State() ConnState {
+	self.stateMtx.Lock()
+	defer self.stateMtx.Unlock()
+	return self.state
+}
 
-			select {
-			case err = <-self.err:
-				return err
-			case ev = <-self.auth:
-				if ev.GetContentType() != "auth/request" {
-					c.Close()
-					return errMissingAuthRequest
-				}
+// setState moves to state, unless the socket has been closed. It reports
+// whether the state changed.
+func (self *InboundSocket) setState(state ConnState) bool {
+	self.stateMtx.Lock()
+	defer self.stateMtx.Unlock()
+	if self.state == StateClosed {
+		return false
+	}
+	self.state = state
+	return true
+}
 
-			}
-			ev, err = self.Auth(self.fspassword)
-			if err != nil {
-				c.Close()
-				return errInvalidPassword
-			}
+// OnConnect sets the function called every time the socket is connected,
+// authenticated and subscribed, including after a reconnect. The socket
+// is already connected when DialInbound returns it, so this misses the
+// initial connection; use WithOnConnect for that.
+func (self *InboundSocket) OnConnect(fn func()) {
+	self.stateMtx.Lock()
+	self.onConnect = fn
+	self.stateMtx.Unlock()
+}
 
-			handledEvs := make([]string, len(self.eventHandlers))
-			j := 0
-			for k := range self.eventHandlers {
-				handledEvs[j] = k
-				j++
-			}
+// OnDisconnect sets the function called with the error that broke the
+// connection.
+func (self *InboundSocket) OnDisconnect(fn func(err error)) {
+	self.stateMtx.Lock()
+	self.onDisconnect = fn
+	self.stateMtx.Unlock()
+}
 
-			var eventsCmd string
-			for _, ev := range handledEvs {
-				if ev == "ALL" {
-					eventsCmd = "ALL"
-					break
-				}
-				eventsCmd += " " + ev
-			}
-			eventsCmd += "\n\n"
-			if self.isEventJson {
-				ev, err = self.EventJson(eventsCmd)
-				if err != nil && !ev.IsReplyTextSuccess() {
-					return errFilterFailed
-				}
-			} else {
-				ev, err = self.EventPlain(eventsCmd)
-				if err != nil && !ev.IsReplyTextSuccess() {
-					return errFilterFailed
-				}
-			}
+// OnReconnect sets the function called before each reconnect attempt,
+// starting with attempt 1.
+func (self *InboundSocket) OnReconnect(fn func(attempt int)) {
+	self.stateMtx.Lock()
+	self.onReconnect = fn
+	self.stateMtx.Unlock()
+}
+
+// hooks returns the lifecycle callbacks.
+func (self *InboundSocket) hooks() (func(), func(error), func(int)) {
+	self.stateMtx.Lock()
+	defer self.stateMtx.Unlock()
+	return self.onConnect, self.onDisconnect, self.onReconnect
+}
+
+// Close closes the connection for good: Start returns and no reconnect is
+// attempted, even one waiting out its backoff.
+func (self *InboundSocket) Close() error {
+	self.stateMtx.Lock()
+	if self.state != StateClosed {
+		self.state = StateClosed
+		close(self.closing)
+	}
+	es := self.EventSocket
+	self.stateMtx.Unlock()
+	if es == nil {
+		return nil
+	}
+	return es.Disconnect()
+}
 
+// dial opens a new connection and authenticates. The first connection
+// creates the EventSocket, later ones are attached to it so handlers and
+// recorded subscriptions carry over.
+func (self *InboundSocket) dial(ctx context.Context) error {
+	if !self.setState(StateConnecting) {
+		return errClosed
+	}
+	c, err := self.dialer.DialContext(ctx, "tcp", self.fsaddress)
+	if err != nil {
+		return err
+	}
+	if !self.setState(StateAuthenticating) {
+		c.Close()
+		return errClosed
+	}
+	self.stateMtx.Lock()
+	if self.EventSocket == nil {
+		es := NewEventSocket(c, self.eventHandlers)
+		es.SetLogger(self.logger)
+		es.SetDispatchMode(self.dispatch)
+		self.EventSocket = es
+	} else {
+		self.attach(c)
+	}
+	self.stateMtx.Unlock()
+	go self.readLoop()
+	var ev *Event
+
+	select {
+	case err = <-self.err:
+		return err
+	case ev = <-self.auth:
+		if ev.GetContentType() != "auth/request" {
+			c.Close()
+			return errMissingAuthRequest
+		}
+	case <-ctx.Done():
+		c.Close()
+		return ctx.Err()
+	}
+	if self.user != "" {
+		ev, err = self.UserAuthContext(ctx, self.user, self.domain, self.fspassword)
+	} else {
+		ev, err = self.AuthContext(ctx, self.fspassword)
+	}
+	if err != nil {
+		c.Close()
+		return err
+	}
+	return nil
+}
+
+// subscribe subscribes to the events there are handlers for and those
+// given with WithEvents, then applies the initial filters.
+func (self *InboundSocket) subscribe(ctx context.Context) error {
+	names := make(map[string]bool)
+	for k := range self.eventHandlers {
+		names[k] = true
+	}
+	for _, k := range self.events {
+		names[k] = true
+	}
+	if len(names) > 0 {
+		var ev *Event
+		var err error
+		switch self.format {
+		case "json":
+			ev, err = self.EventJsonContext(ctx, eventList(names))
+		case "xml":
+			ev, err = self.EventXMLContext(ctx, eventList(names))
+		default:
+			ev, err = self.EventPlainContext(ctx, eventList(names))
+		}
+		if err != nil {
+			return err
+		}
+		if !ev.IsReplyTextSuccess() {
+			return errSubscribeFailed
+		}
+	}
+	// A failed attempt may have recorded some of the filters already,
+	// which would keep them from being sent on this connection.
+	self.mtx.Lock()
+	self.EventSocket.filters = nil
+	self.mtx.Unlock()
+	for _, f := range self.filters {
+		ev, err := self.FilterContext(ctx, f)
+		if err != nil {
+			return err
+		}
+		if !ev.IsReplyTextSuccess() {
+			return errFilterFailed
+		}
+	}
+	return nil
+}
+
+// retry dials and runs setup until both succeed or the reconnect policy
+// gives up. A failed attempt's connection is closed before the next one.
+func (self *InboundSocket) retry(ctx context.Context, reconnecting bool, setup func(context.Context) error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		onConnect, _, onReconnect := self.hooks()
+		if reconnecting && onReconnect != nil {
+			onReconnect(attempt)
+		}
+		err := self.dial(ctx)
+		if err == nil {
+			err = setup(ctx)
+		}
+		if err == nil && self.setState(StateSubscribed) {
+			if onConnect != nil {
+				onConnect()
+			}
 			return nil
 		}
-		time.Sleep(2 * time.Second)
+		if self.EventSocket != nil {
+			self.Disconnect()
+			<-self.done()
+		}
+		if self.State() == StateClosed {
+			return errClosed
+		}
+		// Bad credentials will not get any better by retrying.
+		if _, rejected := err.(*AuthError); rejected || err == errMissingAuthRequest || ctx.Err() != nil {
+			return err
+		}
+		d, ok := self.policy.delay(attempt, time.Since(start))
+		if !ok {
+			return err
+		}
+		self.logger.Printf("Connecting to FreeSWITCH failed: %s, retrying in %s", err, d)
+		select {
+		case <-time.After(d):
+		case <-self.closing:
+			return errClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+}
+
+// connect makes the initial connection, retrying per the reconnect policy.
+func (self *InboundSocket) connect(ctx context.Context) error {
+	return self.retry(ctx, false, self.subscribe)
+}
 
-	return err
+// reconnect dials again and replays every subscription, filter,
+// divert_events and myevents command sent on the previous connection. A
+// replay taking longer than replayTimeout counts as a failed attempt.
+func (self *InboundSocket) reconnect() error {
+	return self.retry(context.Background(), true, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, replayTimeout)
+		defer cancel()
+		return self.replay(ctx)
+	})
 }
 
-// Reads events from socket
-func (self *InboundSocket) Start() {
+// Start reads events from the socket and dispatches them to the handlers.
+// When the connection drops it reconnects following the reconnect policy
+// and returns the last error once the policy gives up. After Close it
+// returns nil.
+func (self *InboundSocket) Start() error {
 	for {
 		ev, err := self.readEvent()
 		if err != nil {
-			//if self.logger != nil {
-			//	self.logger.Warning("<FSock> FreeSWITCH connection broken: attempting reconnect")
-			//}
-			connErr := self.connect()
-			if connErr != nil {
-				//return
-				continue
+			self.Disconnect()
+			<-self.done()
+			if !self.setState(StateDisconnected) {
+				return nil
+			}
+			if _, onDisconnect, _ := self.hooks(); onDisconnect != nil {
+				onDisconnect(err)
+			}
+			self.logger.Printf("FreeSWITCH connection broken: %s, reconnecting", err)
+			if err = self.reconnect(); err != nil {
+				if err == errClosed {
+					return nil
+				}
+				return err
 			}
-			time.Sleep(2 * time.Second)
-
 			continue // Connection reset
 		}
-		go self.dispatchEvent(ev)
+		self.dispatchEvent(ev)
+	}
+}
+
+// DialInbound connects to the FreeSWITCH event socket at addr,
+// authenticates and subscribes as configured by opts. ctx bounds the whole
+// initial connection, retries included; call Start to read events.
+func DialInbound(ctx context.Context, addr string, opts ...InboundOption) (*InboundSocket, error) {
+	inboundSocket := &InboundSocket{
+		fsaddress:  addr,
+		fspassword: "ClueCon",
+		policy:     DefaultReconnectPolicy,
+		format:     "plain",
+		logger:     stdLogger{},
+		closing:    make(chan struct{}),
 	}
-	return
+	for _, opt := range opts {
+		opt(inboundSocket)
+	}
+	if err := inboundSocket.connect(ctx); err != nil {
+		return nil, err
+	}
+	return inboundSocket, nil
 }
+
+// NewInboundSocket connects like DialInbound, trying reconnects times (at
+// least once) two seconds apart. Start then reconnects forever, two seconds
+// apart.
 func NewInboundSocket(address string, password string, reconnects int, isEventJson bool, eventHandlers map[string][]func(*Event)) (*InboundSocket, error) {
-	inboundSocket := InboundSocket{fsaddress: address, fspassword: password, reconnects: reconnects, isEventJson: isEventJson, eventHandlers: eventHandlers}
-	err := inboundSocket.connect()
+	format := "plain"
+	if isEventJson {
+		format = "json"
+	}
+	if reconnects < 1 {
+		reconnects = 1
+	}
+	policy := ReconnectPolicy{InitialBackoff: 2 * time.Second, MaxBackoff: 2 * time.Second, MaxAttempts: reconnects}
+	inboundSocket, err := DialInbound(context.Background(), address,
+		WithPassword(password),
+		WithEventFormat(format),
+		WithEventHandlers(eventHandlers),
+		WithReconnectPolicy(policy))
 	if err != nil {
 		return nil, err
 	}
-	return &inboundSocket, nil
+	policy.MaxAttempts = 0
+	inboundSocket.SetReconnectPolicy(policy)
+	return inboundSocket, nil
 }
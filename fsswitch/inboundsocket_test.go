@@ -0,0 +1,179 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconnectPolicyDelay(t *testing.T) {
+	tests := []struct {
+		policy  ReconnectPolicy
+		attempt int
+		want    time.Duration
+		ok      bool
+	}{
+		{ReconnectPolicy{InitialBackoff: time.Second}, 1, time.Second, true},
+		{ReconnectPolicy{InitialBackoff: time.Second}, 3, 4 * time.Second, true},
+		{ReconnectPolicy{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second}, 3, 3 * time.Second, true},
+		{ReconnectPolicy{InitialBackoff: time.Second, Multiplier: 3}, 2, 3 * time.Second, true},
+		{ReconnectPolicy{MaxAttempts: 5}, 1, DefaultReconnectPolicy.InitialBackoff, true},
+		{ReconnectPolicy{MaxAttempts: 5}, 5, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := tt.policy.delay(tt.attempt, 0)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%+v.delay(%d) = %s, %t, want %s, %t", tt.policy, tt.attempt, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestReconnectPolicyMaxElapsed(t *testing.T) {
+	p := ReconnectPolicy{InitialBackoff: time.Second, MaxElapsed: time.Minute}
+	if _, ok := p.delay(1, time.Minute); ok {
+		t.Error("delay after MaxElapsed should give up")
+	}
+}
+
+// chanLogger sends every message to a channel, dropping those nobody
+// waits for.
+type chanLogger chan string
+
+func (l chanLogger) Printf(format string, v ...interface{}) {
+	select {
+	case l <- fmt.Sprintf(format, v...):
+	default:
+	}
+}
+
+// serveAuth accepts a single connection on ln and authenticates it.
+func serveAuth(t *testing.T, ln net.Listener) net.Conn {
+	c, err := ln.Accept()
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	io.WriteString(c, "Content-Type: auth/request\n\n")
+	r := bufio.NewReader(c)
+	if line, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "auth ") {
+		t.Errorf("got %q, %v instead of auth", line, err)
+	}
+	r.ReadString('\n')
+	io.WriteString(c, "Content-Type: command/reply\nReply-Text: +OK accepted\n\n")
+	return c
+}
+
+func TestCloseInterruptsBackoff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan net.Conn, 1)
+	go func() { conns <- serveAuth(t, ln) }()
+	logs := make(chanLogger)
+	s, err := DialInbound(context.Background(), ln.Addr().String(),
+		WithLogger(logs),
+		WithReconnectPolicy(ReconnectPolicy{InitialBackoff: time.Minute}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+
+	// Drop the connection and refuse the reconnect.
+	ln.Close()
+	if c := <-conns; c != nil {
+		c.Close()
+	}
+	for msg := range logs {
+		if strings.Contains(msg, "retrying in") {
+			break
+		}
+	}
+	// The connection is already gone, so Close only stops the retries.
+	s.Close()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Errorf("Start returned %v after Close", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start still running after Close")
+	}
+	if s.State() != StateClosed {
+		t.Errorf("state is %s", s.State())
+	}
+}
+
+func TestDialInboundSubscribeErrors(t *testing.T) {
+	tests := []struct {
+		opts  []InboundOption
+		reply string
+		want  error
+	}{
+		{[]InboundOption{WithEvents("HEARTBEAT")}, "-ERR no keywords supplied", errSubscribeFailed},
+		{[]InboundOption{WithFilter("Event-Name", "HEARTBEAT")}, "-ERR invalid syntax", errFilterFailed},
+	}
+	for _, tt := range tests {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(reply string) {
+			c := serveAuth(t, ln)
+			if c == nil {
+				return
+			}
+			defer c.Close()
+			r := bufio.NewReader(c)
+			r.ReadString('\n')
+			r.ReadString('\n')
+			io.WriteString(c, "Content-Type: command/reply\nReply-Text: "+reply+"\n\n")
+			r.ReadString('\n')
+		}(tt.reply)
+		opts := append(tt.opts, WithLogger(nopLogger{}), WithReconnectPolicy(ReconnectPolicy{MaxAttempts: 1}))
+		if _, err := DialInbound(context.Background(), ln.Addr().String(), opts...); err != tt.want {
+			t.Errorf("got %v, want %v", err, tt.want)
+		}
+		ln.Close()
+	}
+}
+
+func TestWithOnConnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if c := serveAuth(t, ln); c != nil {
+			defer c.Close()
+			bufio.NewReader(c).ReadString('\n')
+		}
+	}()
+	connects := 0
+	s, err := DialInbound(context.Background(), ln.Addr().String(),
+		WithLogger(nopLogger{}),
+		WithOnConnect(func() { connects++ }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if connects != 1 {
+		t.Errorf("OnConnect called %d times for the initial connection", connects)
+	}
+	if s.State() != StateSubscribed {
+		t.Errorf("state is %s", s.State())
+	}
+}
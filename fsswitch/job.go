@@ -0,0 +1,105 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// JobError is the result of a background job that FreeSWITCH answered
+// with -ERR.
+type JobError struct {
+	UUID    string
+	Message string
+}
+
+func (j *JobError) Error() string {
+	return fmt.Sprintf("job %s: -ERR %s", j.UUID, j.Message)
+}
+
+// Job is a bgapi command running in the background on FreeSWITCH. The
+// socket resolves it when the BACKGROUND_JOB event with the same Job-UUID
+// arrives.
+type Job struct {
+	UUID  string // Job-UUID sent along with the bgapi command
+	Reply *Event // command/reply to the bgapi command itself
+	done  chan struct{}
+	event *Event
+	err   error
+}
+
+func newJob(uuid string) *Job {
+	return &Job{UUID: uuid, done: make(chan struct{})}
+}
+
+// resolve completes the job with the BACKGROUND_JOB event or an error.
+func (j *Job) resolve(ev *Event, err error) {
+	j.event, j.err = ev, err
+	close(j.done)
+}
+
+// Done returns a channel that is closed once the job has completed.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Event returns the BACKGROUND_JOB event, or nil if the job has not
+// completed yet.
+func (j *Job) Event() *Event {
+	select {
+	case <-j.done:
+		return j.event
+	default:
+		return nil
+	}
+}
+
+// Result returns the output of a completed job with the leading +OK
+// removed. A -ERR reply is returned as a *JobError.
+func (j *Job) Result() (string, error) {
+	select {
+	case <-j.done:
+	default:
+		return "", errJobPending
+	}
+	if j.err != nil {
+		return "", j.err
+	}
+	body := strings.TrimSpace(j.event.Body)
+	switch {
+	case strings.HasPrefix(body, "+OK"):
+		return strings.TrimSpace(strings.TrimPrefix(body, "+OK")), nil
+	case strings.HasPrefix(body, "-ERR"):
+		return "", &JobError{UUID: j.UUID, Message: strings.TrimSpace(strings.TrimPrefix(body, "-ERR"))}
+	}
+	return body, nil
+}
+
+// Wait blocks until the job completes or ctx is done, then returns its
+// Result.
+func (j *Job) Wait(ctx context.Context) (string, error) {
+	select {
+	case <-j.done:
+		return j.Result()
+	case <-ctx.Done():
+		return "", contextError("bgapi", ctx.Err())
+	}
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
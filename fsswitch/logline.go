@@ -0,0 +1,75 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"strconv"
+	"strings"
+)
+
+// LogLine is a FreeSWITCH console log line received as a log/data frame
+// after a `log` command.
+type LogLine struct {
+	Level    int    // Log-Level, 0 (console) to 7 (debug)
+	Channel  int    // Text-Channel
+	File     string // Log-File
+	Line     int    // Log-Line
+	Function string // Log-Func
+	UUID     string // Channel UUID the line belongs to, if any
+	UserData string // User-Data
+	Body     string // The log message itself
+}
+
+// newLogLine builds a LogLine from a log/data frame.
+func newLogLine(frame *Event) *LogLine {
+	line := &LogLine{
+		File:     frame.GetHeader("Log-File", ""),
+		Function: frame.GetHeader("Log-Func", ""),
+		UserData: frame.GetHeader("User-Data", ""),
+		Body:     frame.Body,
+	}
+	line.Level, _ = frame.GetInt("Log-Level")
+	line.Channel, _ = frame.GetInt("Text-Channel")
+	line.Line, _ = frame.GetInt("Log-Line")
+	// Session logs carry the channel UUID as user data.
+	if len(line.UserData) == 36 && strings.Count(line.UserData, "-") == 4 {
+		line.UUID = line.UserData
+	}
+	return line
+}
+
+func (l *LogLine) String() string {
+	return l.File + ":" + strconv.Itoa(l.Line) + " " + l.Function + "() " + strings.TrimRight(l.Body, "\n")
+}
+
+// OnLog adds fn to the functions called, in order, with every log line
+// received. Log lines are only sent after Log has been called. The
+// functions run one after the other on a goroutine of their own; while
+// they are more than a few lines behind, further lines are dropped so the
+// socket keeps reading command replies and events.
+func (e *EventSocket) OnLog(fn func(*LogLine)) {
+	e.mtx.Lock()
+	e.logHandlers = append(e.logHandlers, fn)
+	e.mtx.Unlock()
+}
+
+// logLoop hands log lines to the log handlers until the read loop exits.
+func (e *EventSocket) logLoop(closed chan struct{}) {
+	for {
+		select {
+		case line := <-e.logs:
+			e.mtx.Lock()
+			handlers := e.logHandlers
+			e.mtx.Unlock()
+			for _, fn := range handlers {
+				fn(line)
+			}
+		case <-closed:
+			return
+		}
+	}
+}
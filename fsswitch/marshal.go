@@ -0,0 +1,140 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// headerKeys returns the header keys of ev in the order they are written
+// out: Event-Name first, like FreeSWITCH does, then sorted. Content-Length
+// is left out, it is written from the body.
+func (self *Event) headerKeys() []string {
+	keys := make([]string, 0, len(self.Header))
+	for k := range self.Header {
+		if !strings.EqualFold(k, "Content-Length") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if a, b := keys[i] == "Event-Name", keys[j] == "Event-Name"; a != b {
+			return a
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// urlEncode encodes a header value the way FreeSWITCH does, with spaces as
+// %20 rather than +.
+func urlEncode(v string) string {
+	return strings.Replace(url.QueryEscape(v), "+", "%20", -1)
+}
+
+// MarshalPlain encodes ev as FreeSWITCH sends it in text/event-plain: one
+// "Name: value" line per header with URL-encoded values, then the body
+// preceded by its Content-Length, if there is a body. This is the payload
+// of the text/event-plain frame, without its own Content-Type and
+// Content-Length.
+func (self *Event) MarshalPlain() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, k := range self.headerKeys() {
+		buf.WriteString(k + ": " + urlEncode(self.Header[k]) + "\n")
+	}
+	if self.Body != "" {
+		buf.WriteString("Content-Length: " + strconv.Itoa(len(self.Body)) + "\n\n")
+		buf.WriteString(self.Body)
+	} else {
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON encodes ev as FreeSWITCH sends it in text/event-json: an
+// object of header values, with arrays for array headers, and the body in
+// _body along with its Content-Length.
+func (self *Event) MarshalJSON() ([]byte, error) {
+	obj := make(map[string]interface{}, len(self.Header)+2)
+	for _, k := range self.headerKeys() {
+		v := self.Header[k]
+		if strings.HasPrefix(v, arrayPrefix) {
+			obj[k] = splitValues(v)
+		} else {
+			obj[k] = v
+		}
+	}
+	if self.Body != "" {
+		obj["Content-Length"] = strconv.Itoa(len(self.Body))
+		obj["_body"] = self.Body
+	}
+	return json.Marshal(obj)
+}
+
+// UnmarshalJSON decodes an event in the text/event-json layout, the
+// inverse of MarshalJSON.
+func (self *Event) UnmarshalJSON(data []byte) error {
+	ev, err := decodeJSONEvent(&Event{Body: string(data)})
+	if err != nil {
+		return err
+	}
+	*self = *ev
+	return nil
+}
+
+// MarshalXML encodes ev as FreeSWITCH sends it in text/event-xml:
+// <event><headers><Name>value</Name>...</headers><body>...</body></event>
+// with URL-encoded header values. Use it through xml.Marshal(ev).
+func (self *Event) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	start = xml.StartElement{Name: xml.Name{Local: "event"}}
+	headers := xml.StartElement{Name: xml.Name{Local: "headers"}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(headers); err != nil {
+		return err
+	}
+	for _, k := range self.headerKeys() {
+		if err := enc.EncodeElement(urlEncode(self.Header[k]), xml.StartElement{Name: xml.Name{Local: k}}); err != nil {
+			return err
+		}
+	}
+	if self.Body != "" {
+		length := xml.StartElement{Name: xml.Name{Local: "Content-Length"}}
+		if err := enc.EncodeElement(strconv.Itoa(len(self.Body)), length); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(headers.End()); err != nil {
+		return err
+	}
+	if self.Body != "" {
+		if err := enc.EncodeElement(self.Body, xml.StartElement{Name: xml.Name{Local: "body"}}); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(start.End()); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// UnmarshalXML decodes an event in the text/event-xml layout, the inverse
+// of MarshalXML. Use it through xml.Unmarshal(data, ev).
+func (self *Event) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var tmp xmlEvent
+	if err := dec.DecodeElement(&tmp, &start); err != nil {
+		return err
+	}
+	*self = *tmp.event()
+	return nil
+}
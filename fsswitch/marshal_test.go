@@ -0,0 +1,114 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testEvent() *Event {
+	return &Event{
+		Header: map[string]string{
+			"Event-Name":            "CUSTOM",
+			"Event-Subclass":        "my::ev",
+			"variable_a":            "ARRAY::x y|:z+1",
+			"Caller-Caller-ID-Name": "Bob & <Al>",
+			"Empty":                 "",
+		},
+		Body: "hello\nworld",
+	}
+}
+
+// withoutLength returns ev's headers without Content-Length, which the
+// decoders keep and the encoders write from the body.
+func withoutLength(ev *Event) map[string]string {
+	h := make(map[string]string, len(ev.Header))
+	for k, v := range ev.Header {
+		if k != "Content-Length" {
+			h[k] = v
+		}
+	}
+	return h
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, ev := range []*Event{testEvent(), {Header: map[string]string{"Event-Name": "HEARTBEAT"}}} {
+		plain, err := ev.MarshalPlain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodePlainEvent(&Event{Body: string(plain)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(withoutLength(got), ev.Header) || got.Body != ev.Body {
+			t.Errorf("plain: got %v %q, want %v %q", got.Header, got.Body, ev.Header, ev.Body)
+		}
+
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = &Event{}
+		if err := json.Unmarshal(data, got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(withoutLength(got), ev.Header) || got.Body != ev.Body {
+			t.Errorf("json: got %v %q, want %v %q", got.Header, got.Body, ev.Header, ev.Body)
+		}
+
+		data, err = xml.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = &Event{}
+		if err := xml.Unmarshal(data, got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(withoutLength(got), ev.Header) || got.Body != ev.Body {
+			t.Errorf("xml: got %v %q, want %v %q", got.Header, got.Body, ev.Header, ev.Body)
+		}
+	}
+}
+
+func TestMarshalStaleContentLength(t *testing.T) {
+	ev := &Event{Header: map[string]string{"Event-Name": "HEARTBEAT", "Content-Length": "42"}}
+	plain, err := ev.MarshalPlain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(plain), "Content-Length") {
+		t.Errorf("plain: stale Content-Length written: %q", plain)
+	}
+	data, err := xml.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Content-Length") {
+		t.Errorf("xml: stale Content-Length written: %s", data)
+	}
+	data, err = json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Content-Length") {
+		t.Errorf("json: stale Content-Length written: %s", data)
+	}
+
+	ev.Body = "abc"
+	plain, err = ev.MarshalPlain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Event-Name: HEARTBEAT\nContent-Length: 3\n\nabc"; string(plain) != want {
+		t.Errorf("plain = %q, want %q", plain, want)
+	}
+}
@@ -0,0 +1,267 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var errNoFilters = errors.New("Matcher has no header equality to filter on")
+
+// Matcher selects events, e.g. to register a handler with OnMatch:
+//
+//	And(EventName("CHANNEL_ANSWER"),
+//		HeaderEquals("Call-Direction", "inbound"),
+//		Variable("tenant_id", "42"))
+type Matcher interface {
+	Match(ev *Event) bool
+}
+
+// MatcherFunc turns a function into a Matcher.
+type MatcherFunc func(ev *Event) bool
+
+func (f MatcherFunc) Match(ev *Event) bool {
+	return f(ev)
+}
+
+type headerEquals struct{ name, value string }
+
+func (m headerEquals) Match(ev *Event) bool {
+	v, ok := ev.LookupHeader(m.name)
+	return ok && v == m.value
+}
+
+// HeaderEquals matches events whose header name is value.
+func HeaderEquals(name, value string) Matcher {
+	return headerEquals{name, value}
+}
+
+// EventName matches events named name. CUSTOM events can be given with
+// their subclass, e.g. "CUSTOM sofia::register".
+func EventName(name string) Matcher {
+	if name, subclass := splitEventKey(name); subclass != "" {
+		return And(headerEquals{"Event-Name", name}, headerEquals{"Event-Subclass", subclass})
+	}
+	return headerEquals{"Event-Name", name}
+}
+
+// Variable matches events carrying the channel variable name set to value.
+func Variable(name, value string) Matcher {
+	return headerEquals{"variable_" + name, value}
+}
+
+type headerPrefix struct{ name, prefix string }
+
+func (m headerPrefix) Match(ev *Event) bool {
+	v, ok := ev.LookupHeader(m.name)
+	return ok && strings.HasPrefix(v, m.prefix)
+}
+
+// HeaderPrefix matches events whose header name starts with prefix.
+func HeaderPrefix(name, prefix string) Matcher {
+	return headerPrefix{name, prefix}
+}
+
+type headerRegexp struct {
+	name string
+	re   *regexp.Regexp
+}
+
+func (m headerRegexp) Match(ev *Event) bool {
+	v, ok := ev.LookupHeader(m.name)
+	return ok && m.re.MatchString(v)
+}
+
+// HeaderMatches matches events whose header name matches re.
+func HeaderMatches(name string, re *regexp.Regexp) Matcher {
+	return headerRegexp{name, re}
+}
+
+type and []Matcher
+
+func (m and) Match(ev *Event) bool {
+	for _, sub := range m {
+		if !sub.Match(ev) {
+			return false
+		}
+	}
+	return true
+}
+
+// And matches events matched by all of ms.
+func And(ms ...Matcher) Matcher {
+	return and(ms)
+}
+
+type or []Matcher
+
+func (m or) Match(ev *Event) bool {
+	for _, sub := range m {
+		if sub.Match(ev) {
+			return true
+		}
+	}
+	return false
+}
+
+// Or matches events matched by any of ms.
+func Or(ms ...Matcher) Matcher {
+	return or(ms)
+}
+
+type not struct{ m Matcher }
+
+func (m not) Match(ev *Event) bool {
+	return !m.m.Match(ev)
+}
+
+// Not matches events m doesn't match.
+func Not(m Matcher) Matcher {
+	return not{m}
+}
+
+// equalities returns header equalities, restricted to the headers keep
+// accepts, such that every event m matches satisfies at least one of them.
+// It reports false if m has no such set.
+func equalities(m Matcher, keep func(name string) bool) ([]headerEquals, bool) {
+	switch m := m.(type) {
+	case headerEquals:
+		if keep(m.name) {
+			return []headerEquals{m}, true
+		}
+	case and:
+		// Any operand will do; the last one narrows the most in the
+		// usual And(EventName(...), ...) form.
+		var found []headerEquals
+		for _, sub := range m {
+			if eqs, ok := equalities(sub, keep); ok {
+				found = eqs
+			}
+		}
+		return found, found != nil
+	case or:
+		var all []headerEquals
+		for _, sub := range m {
+			eqs, ok := equalities(sub, keep)
+			if !ok {
+				return nil, false
+			}
+			all = append(all, eqs...)
+		}
+		return all, len(m) > 0
+	}
+	return nil, false
+}
+
+// matchKeys returns the handler keys of the events m can match, or ALL.
+func matchKeys(m Matcher) []string {
+	eqs, ok := equalities(m, func(name string) bool {
+		return strings.EqualFold(name, "Event-Name") || strings.EqualFold(name, "Event-Subclass")
+	})
+	if !ok {
+		return []string{"ALL"}
+	}
+	keys := make(map[string]bool)
+	for _, eq := range eqs {
+		if strings.EqualFold(eq.name, "Event-Subclass") {
+			keys[eventKey("CUSTOM", eq.value)] = true
+		} else {
+			keys[eq.value] = true
+		}
+	}
+	return sortedKeys(keys)
+}
+
+// OnMatch adds fn to the handlers called with the events m matches and
+// returns a function removing it again. The socket subscribes to the
+// events named in m as On does; a matcher not tied to event names needs
+// every event and subscribes to ALL. A failed subscribe is logged; use
+// OnMatchContext to get the error.
+func (e *EventSocket) OnMatch(m Matcher, fn func(*Event)) (unsubscribe func()) {
+	unsubscribe, err := e.OnMatchContext(context.Background(), m, fn)
+	if err != nil {
+		e.logger.Printf("Error subscribing for matcher: %s", err)
+		return func() {}
+	}
+	return unsubscribe
+}
+
+// OnMatchContext is like OnMatch but honors the deadline and cancellation
+// of ctx. If subscribing to any of the events fails, fn is not added for
+// any of them and the error is returned.
+func (e *EventSocket) OnMatchContext(ctx context.Context, m Matcher, fn func(*Event)) (unsubscribe func(), err error) {
+	handler := func(ev *Event) {
+		if m.Match(ev) {
+			fn(ev)
+		}
+	}
+	var offs []func()
+	unsubscribe = func() {
+		for _, off := range offs {
+			off()
+		}
+	}
+	for _, key := range matchKeys(m) {
+		off, err := e.OnContext(ctx, key, handler)
+		if err != nil {
+			unsubscribe()
+			return nil, err
+		}
+		offs = append(offs, off)
+	}
+	return unsubscribe, nil
+}
+
+// filterValue returns v as a filter value FreeSWITCH compares literally.
+// It reads a value starting with / as a regular expression and one
+// starting with + or - as a sign, so those are sent as an anchored regular
+// expression matching v.
+func filterValue(v string) string {
+	if v == "" || !strings.ContainsAny(v[:1], "/+-") {
+		return v
+	}
+	return "/^" + regexp.QuoteMeta(v) + "$/"
+}
+
+// PushFilters adds the filters FreeSWITCH needs to send only events that
+// may match m, built from the header equalities in m. Other events are not
+// filtered out exactly, but no event m matches is. Filters apply to the
+// whole socket: add filters for every matcher in use, or the events the
+// other handlers expect stop arriving. The BACKGROUND_JOB and
+// CHANNEL_EXECUTE_COMPLETE events the socket waits for itself are kept.
+func (e *EventSocket) PushFilters(ctx context.Context, m Matcher) error {
+	eqs, ok := equalities(m, func(string) bool { return true })
+	if !ok {
+		return errNoFilters
+	}
+	for _, eq := range eqs {
+		ev, err := e.FilterContext(ctx, eq.name+" "+filterValue(eq.value))
+		if err != nil {
+			return err
+		}
+		if !ev.IsReplyTextSuccess() {
+			return errFilterFailed
+		}
+	}
+	e.mtx.Lock()
+	jobs, wait := len(e.jobs) > 0, e.waitExecute
+	e.mtx.Unlock()
+	if jobs {
+		if err := e.keepEvent(ctx, "BACKGROUND_JOB"); err != nil {
+			return err
+		}
+	}
+	if wait {
+		if err := e.keepEvent(ctx, "CHANNEL_EXECUTE_COMPLETE"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
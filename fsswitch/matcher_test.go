@@ -0,0 +1,87 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestFilterValue(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"CHANNEL_ANSWER", "CHANNEL_ANSWER"},
+		{"", ""},
+		{"a/b", "a/b"},
+		{"/tmp/x.wav", `/^/tmp/x\.wav$/`},
+		{"+15551234", `/^\+15551234$/`},
+		{"-1", `/^-1$/`},
+	}
+	for _, tt := range tests {
+		if got := filterValue(tt.in); got != tt.want {
+			t.Errorf("filterValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if tt.in != "" && tt.in != tt.want {
+			re := regexp.MustCompile(tt.want[1 : len(tt.want)-1])
+			if !re.MatchString(tt.in) {
+				t.Errorf("%s does not match %q", tt.want, tt.in)
+			}
+		}
+	}
+}
+
+func TestMatchKeys(t *testing.T) {
+	tests := []struct {
+		m    Matcher
+		want []string
+	}{
+		{EventName("CHANNEL_ANSWER"), []string{"CHANNEL_ANSWER"}},
+		{EventName("CUSTOM sofia::register"), []string{"CUSTOM sofia::register"}},
+		{Or(EventName("CHANNEL_ANSWER"), EventName("CHANNEL_HANGUP")), []string{"CHANNEL_ANSWER", "CHANNEL_HANGUP"}},
+		{And(EventName("CHANNEL_ANSWER"), Variable("tenant_id", "42")), []string{"CHANNEL_ANSWER"}},
+		{Variable("tenant_id", "42"), []string{"ALL"}},
+		{Not(EventName("HEARTBEAT")), []string{"ALL"}},
+	}
+	for _, tt := range tests {
+		if got := matchKeys(tt.m); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("matchKeys(%v) = %v, want %v", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestOnMatchContextRollback(t *testing.T) {
+	e, srv := newTestSocket(t)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := e.OnMatchContext(context.Background(), Or(EventName("CHANNEL_ANSWER"), EventName("CHANNEL_HANGUP")), func(*Event) {})
+		errs <- err
+	}()
+	if cmd := srv.mustCommand(t); cmd != "event plain CHANNEL_ANSWER" {
+		t.Fatalf("got command %q", cmd)
+	}
+	srv.replyOK(t)
+	if cmd := srv.mustCommand(t); cmd != "event plain CHANNEL_HANGUP" {
+		t.Fatalf("got command %q", cmd)
+	}
+	io.WriteString(srv.conn, "Content-Type: command/reply\nReply-Text: -ERR denied\n\n")
+	// The handler already added for CHANNEL_ANSWER is removed again.
+	if cmd := srv.mustCommand(t); cmd != "nixevent CHANNEL_ANSWER" {
+		t.Fatalf("got command %q", cmd)
+	}
+	srv.replyOK(t)
+	if err := <-errs; err == nil || err.Error() != "-ERR denied" {
+		t.Fatalf("got error %v", err)
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if len(e.eventHandlers) != 0 {
+		t.Errorf("handlers left: %v", e.eventHandlers)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"net"
+	"time"
+)
+
+// InboundOption configures an InboundSocket created by DialInbound.
+type InboundOption func(*InboundSocket)
+
+// WithPassword sets the password sent with `auth`. It defaults to ClueCon,
+// the FreeSWITCH default.
+func WithPassword(password string) InboundOption {
+	return func(s *InboundSocket) {
+		s.fspassword = password
+	}
+}
+
+// WithUserAuth authenticates with `userauth user@domain:password` instead
+// of `auth`, for event_socket setups restricting users through the
+// directory.
+func WithUserAuth(user, domain, password string) InboundOption {
+	return func(s *InboundSocket) {
+		s.user, s.domain, s.fspassword = user, domain, password
+	}
+}
+
+// WithEventFormat sets the format events are subscribed in: "plain"
+// (default), "json" or "xml".
+func WithEventFormat(format string) InboundOption {
+	return func(s *InboundSocket) {
+		s.format = format
+	}
+}
+
+// WithEvents subscribes to the named events on connect, in addition to
+// those there are handlers for.
+func WithEvents(names ...string) InboundOption {
+	return func(s *InboundSocket) {
+		s.events = append(s.events, names...)
+	}
+}
+
+// WithEventHandlers sets the handlers events are dispatched to. Every event
+// name in handlers is subscribed to on connect.
+func WithEventHandlers(handlers map[string][]func(*Event)) InboundOption {
+	return func(s *InboundSocket) {
+		s.eventHandlers = handlers
+	}
+}
+
+// WithFilter adds an event filter applied on connect.
+func WithFilter(header, value string) InboundOption {
+	return func(s *InboundSocket) {
+		s.filters = append(s.filters, header+" "+value)
+	}
+}
+
+// WithDialTimeout bounds each connection attempt.
+func WithDialTimeout(timeout time.Duration) InboundOption {
+	return func(s *InboundSocket) {
+		s.dialer.Timeout = timeout
+	}
+}
+
+// WithDialer sets the dialer used to connect, e.g. to pick a local address
+// or set keep-alives. It replaces any WithDialTimeout given before it.
+func WithDialer(dialer *net.Dialer) InboundOption {
+	return func(s *InboundSocket) {
+		s.dialer = *dialer
+	}
+}
+
+// WithLogger sets where the socket writes its diagnostics. It defaults to
+// the standard logger.
+func WithLogger(logger Logger) InboundOption {
+	return func(s *InboundSocket) {
+		s.logger = logger
+	}
+}
+
+// WithReconnectPolicy sets how connecting and reconnecting is retried. It
+// defaults to DefaultReconnectPolicy.
+func WithReconnectPolicy(policy ReconnectPolicy) InboundOption {
+	return func(s *InboundSocket) {
+		s.policy = policy
+	}
+}
+
+// WithOnConnect sets the function called every time the socket is
+// connected, authenticated and subscribed, starting with the initial
+// connection made by DialInbound. See InboundSocket.OnConnect.
+func WithOnConnect(fn func()) InboundOption {
+	return func(s *InboundSocket) {
+		s.onConnect = fn
+	}
+}
+
+// WithOnDisconnect sets the function called with the error that broke the
+// connection. See InboundSocket.OnDisconnect.
+func WithOnDisconnect(fn func(err error)) InboundOption {
+	return func(s *InboundSocket) {
+		s.onDisconnect = fn
+	}
+}
+
+// WithOnReconnect sets the function called before each reconnect attempt.
+// See InboundSocket.OnReconnect.
+func WithOnReconnect(fn func(attempt int)) InboundOption {
+	return func(s *InboundSocket) {
+		s.onReconnect = fn
+	}
+}
+
+// ConnectOption configures how OutboundSocket.Connect sets up the
+// connection.
+type ConnectOption func(*OutboundSocket)
+
+// WithLinger makes FreeSWITCH keep the connection open after the channel
+// hangs up, so CHANNEL_HANGUP_COMPLETE and the CDR variables are still
+// delivered. The socket reads on until FreeSWITCH closes it, at the latest
+// after timeout if it is not 0.
+func WithLinger(timeout time.Duration) ConnectOption {
+	return func(s *OutboundSocket) {
+		s.linger, s.lingerTimeout = true, timeout
+	}
+}
+
+// WithMyEvents subscribes to every event of the channel of the connection.
+func WithMyEvents() ConnectOption {
+	return func(s *OutboundSocket) {
+		s.ownEvents = true
+	}
+}
+
+// WithAsyncFull is for the socket application run with `async full`, where
+// the channel runs applications on its own thread while the socket takes
+// any command. The session has to ask for the events of its channel and for
+// the connection to outlive the hangup itself, so Connect issues myevents
+// and linger.
+func WithAsyncFull() ConnectOption {
+	return func(s *OutboundSocket) {
+		s.ownEvents = true
+		s.linger = true
+	}
+}
+
+// WithDispatchMode sets how events are handed to the handlers. It defaults
+// to DispatchOrdered.
+func WithDispatchMode(mode DispatchMode) InboundOption {
+	return func(s *InboundSocket) {
+		s.dispatch = mode
+	}
+}
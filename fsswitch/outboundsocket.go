@@ -8,55 +8,95 @@ Provides FreeSWITCH socket communication.
 package fsswitch
 
 import (
+	"context"
 	"errors"
 
 	"log"
 	"net"
+	"strconv"
+	"sync"
+	"time"
 )
 
-var errFilterFail = errors.New("Event filter failed")
+var errLingerFailed = errors.New("Linger failed")
+
+// ErrServerClosed is returned by OutboundServer.ListenAndServe after
+// Shutdown or Close.
+var ErrServerClosed = errors.New("Outbound server closed")
 
 type OutboundSocket struct {
 	Channel *Event
 	*EventSocket
-	conn net.Conn
+	conn          net.Conn
+	ctx           context.Context
+	cancel        context.CancelFunc
+	linger        bool
+	lingerTimeout time.Duration
+	ownEvents     bool // Send myevents on connect
 }
 
-func (self *OutboundSocket) Connect(eventHandlers map[string][]func(*Event), isEventJson bool) error {
+// Connect sends connect to FreeSWITCH, which replies with the channel data
+// stored in Channel, and subscribes to the events there are handlers for.
+func (self *OutboundSocket) Connect(eventHandlers map[string][]func(*Event), isEventJson bool, opts ...ConnectOption) error {
 	var err error
 	var ev *Event
+	for _, opt := range opts {
+		opt(self)
+	}
 	log.Printf("Remote Address:%s", self.conn.RemoteAddr())
 	self.EventSocket = NewEventSocket(self.conn, eventHandlers)
+	self.observe(self.watchHangup)
 	go self.readLoop()
+	go func() {
+		<-self.done()
+		self.cancel()
+	}()
 	self.Channel, err = self.ChannelConnect()
 	if err != nil {
 		return err
 	}
-	handledEvs := make([]string, len(eventHandlers))
-	j := 0
-	for k := range eventHandlers {
-		handledEvs[j] = k
-		j++
-	}
-	var eventsCmd string
-	for _, ev := range handledEvs {
-		if ev == "ALL" {
-			eventsCmd = "ALL"
-			break
+	if self.ownEvents {
+		ev, err = self.MyEvent("")
+		if err != nil || !ev.IsReplyTextSuccess() {
+			return errSubscribeFailed
 		}
-		eventsCmd += " " + ev
 	}
-	eventsCmd += "\n\n"
+	if self.linger {
+		args := ""
+		if self.lingerTimeout > 0 {
+			// linger takes whole seconds; round up so a short
+			// timeout does not become linger 0.
+			args = strconv.Itoa(int((self.lingerTimeout + time.Second - 1) / time.Second))
+		}
+		ev, err = self.ProtocolSend("linger", args)
+		if err != nil || !ev.IsReplyTextSuccess() {
+			return errLingerFailed
+		}
+	}
+	if len(eventHandlers) == 0 {
+		return nil
+	}
+	handledEvs := make(map[string]bool, len(eventHandlers))
+	for k := range eventHandlers {
+		handledEvs[k] = true
+	}
+	eventsCmd := eventList(handledEvs)
 
 	if isEventJson {
 		ev, err = self.EventJson(eventsCmd)
-		if err != nil && !ev.IsReplyTextSuccess() {
-			return errFilterFailed
+		if err != nil {
+			return err
+		}
+		if !ev.IsReplyTextSuccess() {
+			return errSubscribeFailed
 		}
 	} else {
 		ev, err = self.EventPlain(eventsCmd)
-		if err != nil && !ev.IsReplyTextSuccess() {
-			return errFilterFailed
+		if err != nil {
+			return err
+		}
+		if !ev.IsReplyTextSuccess() {
+			return errSubscribeFailed
 		}
 	}
 
@@ -64,6 +104,35 @@ func (self *OutboundSocket) Connect(eventHandlers map[string][]func(*Event), isE
 	return nil
 }
 
+// Context returns the context of the call: it is cancelled when the
+// channel hangs up or the connection is closed. With linger it is only
+// cancelled once FreeSWITCH closes the connection, after
+// CHANNEL_HANGUP_COMPLETE and the CDR variables have been delivered.
+func (self *OutboundSocket) Context() context.Context {
+	return self.ctx
+}
+
+// watchHangup cancels the call context once the channel hangs up, unless
+// the socket lingers to read the events that follow the hangup.
+func (self *OutboundSocket) watchHangup(ev *Event) {
+	if self.linger {
+		return
+	}
+	switch ev.GetHeader("Event-Name", "") {
+	case "CHANNEL_HANGUP", "CHANNEL_HANGUP_COMPLETE":
+	default:
+		return
+	}
+	if self.Channel == nil || channelUUID(ev) == channelUUID(self.Channel) {
+		self.cancel()
+	}
+}
+
+// channelUUID returns the Unique-ID of ev.
+func channelUUID(ev *Event) string {
+	return ev.GetHeader("Unique-ID", "")
+}
+
 // Reads events from socket
 func (self *OutboundSocket) Start() {
 	for {
@@ -71,34 +140,175 @@ func (self *OutboundSocket) Start() {
 		if err != nil {
 			log.Println("Error occured processing outbound start")
 			self.Disconnect()
+			return
 		}
-		go self.dispatchEvent(ev)
+		self.dispatchEvent(ev)
 	}
 
 }
 func NewOutboundSocket(conn net.Conn) *OutboundSocket {
+	return newOutboundSocket(context.Background(), conn)
+}
+
+// newOutboundSocket returns the socket for conn with a call context
+// derived from ctx.
+func newOutboundSocket(ctx context.Context, conn net.Conn) *OutboundSocket {
 	log.Println("Enter NewOutboundSocket ")
 	outboundSocket := new(OutboundSocket)
 	outboundSocket.conn = conn
+	outboundSocket.ctx, outboundSocket.cancel = context.WithCancel(ctx)
 	return outboundSocket
 }
 
-// HandleFunc is the function called on new incoming connections.
-type HandleFunc func(*OutboundSocket)
+// HandleFunc is the function called on new incoming connections. ctx is
+// cancelled when the channel hangs up (or when the connection ends, with
+// linger), the connection drops or the server gives up waiting in
+// Shutdown.
+type HandleFunc func(ctx context.Context, s *OutboundSocket)
 
-func OutboundServer(addr string, fn HandleFunc) error {
-	srv, err := net.Listen("tcp", addr)
-	if err != nil {
+// OutboundServer accepts the connections FreeSWITCH makes for the socket
+// dialplan application and runs Handler for each of them.
+type OutboundServer struct {
+	Addr     string
+	Handler  HandleFunc
+	MaxConns int // Connections handled at once, 0 for no limit
+
+	mtx      sync.Mutex
+	listener net.Listener
+	conns    map[*OutboundSocket]struct{}
+	closing  bool
+	wg       sync.WaitGroup
+}
 
+func NewOutboundServer(addr string, fn HandleFunc) *OutboundServer {
+	return &OutboundServer{Addr: addr, Handler: fn}
+}
+
+// ListenAndServe listens on Addr and handles connections until ctx is done
+// or the server is shut down. Cancelling ctx also cancels the context of
+// every call being handled.
+func (srv *OutboundServer) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
 		return err
 	}
+	srv.mtx.Lock()
+	if srv.closing {
+		srv.mtx.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	srv.listener = ln
+	srv.conns = make(map[*OutboundSocket]struct{})
+	srv.mtx.Unlock()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ln.Close()
+		case <-stop:
+		}
+	}()
+
+	var slots chan struct{}
+	if srv.MaxConns > 0 {
+		slots = make(chan struct{}, srv.MaxConns)
+	}
 	for {
-		c, err := srv.Accept()
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		c, err := ln.Accept()
 		if err != nil {
+			srv.mtx.Lock()
+			closing := srv.closing
+			srv.mtx.Unlock()
+			switch {
+			case closing:
+				return ErrServerClosed
+			case ctx.Err() != nil:
+				return ctx.Err()
+			}
 			return err
 		}
 		log.Println("Enter OutboundServer")
-		outboundFS := NewOutboundSocket(c)
-		go fn(outboundFS)
+		outboundFS := newOutboundSocket(ctx, c)
+		srv.mtx.Lock()
+		if srv.closing {
+			srv.mtx.Unlock()
+			c.Close()
+			return ErrServerClosed
+		}
+		srv.conns[outboundFS] = struct{}{}
+		srv.wg.Add(1)
+		srv.mtx.Unlock()
+		go srv.serve(outboundFS, slots)
+	}
+}
+
+// serve runs the handler for one connection and closes it afterwards.
+func (srv *OutboundServer) serve(outboundFS *OutboundSocket, slots chan struct{}) {
+	defer srv.wg.Done()
+	srv.Handler(outboundFS.ctx, outboundFS)
+	outboundFS.cancel()
+	outboundFS.conn.Close()
+	srv.mtx.Lock()
+	delete(srv.conns, outboundFS)
+	srv.mtx.Unlock()
+	if slots != nil {
+		<-slots
 	}
 }
+
+// Shutdown stops accepting connections and waits for the calls being
+// handled to finish. If ctx is done first, the remaining calls have their
+// context cancelled and their connection closed, and ctx's error is
+// returned.
+func (srv *OutboundServer) Shutdown(ctx context.Context) error {
+	srv.mtx.Lock()
+	srv.closing = true
+	if srv.listener != nil {
+		srv.listener.Close()
+	}
+	srv.mtx.Unlock()
+	done := make(chan struct{})
+	go func() {
+		srv.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		srv.Close()
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting connections and immediately ends every call being
+// handled.
+func (srv *OutboundServer) Close() error {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+	srv.closing = true
+	var err error
+	if srv.listener != nil {
+		err = srv.listener.Close()
+	}
+	for outboundFS := range srv.conns {
+		outboundFS.cancel()
+		outboundFS.conn.Close()
+	}
+	return err
+}
+
+// ListenAndServeOutbound listens on addr and calls fn for every incoming
+// connection, until accepting fails.
+func ListenAndServeOutbound(addr string, fn HandleFunc) error {
+	return NewOutboundServer(addr, fn).ListenAndServe(context.Background())
+}
@@ -0,0 +1,118 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// blockingServer starts an OutboundServer whose handler reports each call
+// on started and returns once it receives from release.
+func blockingServer(t *testing.T, maxConns int) (srv *OutboundServer, started chan *OutboundSocket, release chan struct{}, errc chan error) {
+	started = make(chan *OutboundSocket)
+	release = make(chan struct{})
+	srv = NewOutboundServer("127.0.0.1:0", func(ctx context.Context, s *OutboundSocket) {
+		started <- s
+		<-release
+	})
+	srv.MaxConns = maxConns
+	errc = make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe(context.Background())
+	}()
+	t.Cleanup(func() { srv.Close() })
+	return srv, started, release, errc
+}
+
+// dial connects to srv once it is listening.
+func dial(t *testing.T, srv *OutboundServer) net.Conn {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		srv.mtx.Lock()
+		ln := srv.listener
+		srv.mtx.Unlock()
+		if ln != nil {
+			c, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { c.Close() })
+			return c
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server not listening")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShutdownWaitsForHandler(t *testing.T) {
+	srv, started, release, errc := blockingServer(t, 0)
+	dial(t, srv)
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- srv.Shutdown(context.Background())
+	}()
+	if err := <-errc; err != ErrServerClosed {
+		t.Fatalf("ListenAndServe: got %v", err)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v while the handler runs", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown: got %v", err)
+	}
+}
+
+func TestShutdownTimeoutCancelsCalls(t *testing.T) {
+	srv, started, release, _ := blockingServer(t, 0)
+	defer close(release)
+	dial(t, srv)
+	s := <-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown: got %v", err)
+	}
+	select {
+	case <-s.Context().Done():
+	default:
+		t.Error("call context not cancelled")
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	srv, started, release, _ := blockingServer(t, 1)
+	dial(t, srv)
+	<-started
+
+	dial(t, srv)
+	select {
+	case <-started:
+		t.Fatal("second connection handled while the first holds the only slot")
+	case <-time.After(200 * time.Millisecond):
+	}
+	release <- struct{}{}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second connection not handled after the slot was freed")
+	}
+	release <- struct{}{}
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: got %v", err)
+	}
+}
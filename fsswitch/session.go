@@ -0,0 +1,218 @@
+/*
+go-switch is released under the MIT License <http://www.opensource.org/licenses/mit-license.php
+Copyright (C) Temlio Inc. All Rights Reserved.
+
+Provides FreeSWITCH socket communication.
+*/
+package fsswitch
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Session is the call an outbound connection was made for. It knows the
+// UUID of its channel, keeps the channel headers up to date as the channel
+// events arrive, and runs dialplan applications on the channel.
+//
+// Applications are sent with event-lock, so they run one after the other
+// in the order they were sent.
+type Session struct {
+	UUID    string // Unique-ID of the channel
+	socket  *OutboundSocket
+	mtx     sync.Mutex
+	channel map[string]string // Latest channel headers
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewSession connects s with opts if that hasn't been done yet, subscribes
+// to the events of its channel with myevents and starts reading them. Do
+// not call s.Start as well.
+func NewSession(ctx context.Context, s *OutboundSocket, opts ...ConnectOption) (*Session, error) {
+	if s.EventSocket == nil {
+		for _, opt := range opts {
+			opt(s)
+		}
+		// myevents is sent below, once the session watches the events.
+		s.ownEvents = false
+		if err := s.Connect(nil, false); err != nil {
+			return nil, err
+		}
+	}
+	sess := &Session{
+		UUID:    channelUUID(s.Channel),
+		socket:  s,
+		channel: make(map[string]string, len(s.Channel.Header)),
+		done:    make(chan struct{}),
+	}
+	for k, v := range s.Channel.Header {
+		sess.channel[k] = v
+	}
+	s.observe(sess.update)
+	closed := s.done()
+	go func() {
+		<-closed
+		sess.finish()
+	}()
+	ev, err := s.MyEventContext(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	if !ev.IsReplyTextSuccess() {
+		return nil, errSubscribeFailed
+	}
+	go s.Start()
+	return sess, nil
+}
+
+// HandleSession adapts fn to a HandleFunc for OutboundServer: every
+// connection is turned into a Session, connected with opts, before fn is
+// called.
+func HandleSession(fn func(ctx context.Context, s *Session), opts ...ConnectOption) HandleFunc {
+	return func(ctx context.Context, s *OutboundSocket) {
+		sess, err := NewSession(ctx, s, opts...)
+		if err != nil {
+			if s.EventSocket != nil {
+				s.logger.Printf("Error starting session: %s", err)
+			}
+			return
+		}
+		fn(ctx, sess)
+	}
+}
+
+// update merges the headers of the events of the session's channel.
+func (s *Session) update(ev *Event) {
+	if channelUUID(ev) != s.UUID {
+		return
+	}
+	s.mtx.Lock()
+	for k, v := range ev.Header {
+		s.channel[k] = v
+	}
+	s.mtx.Unlock()
+	if ev.GetHeader("Event-Name", "") == "CHANNEL_HANGUP_COMPLETE" {
+		s.finish()
+	}
+}
+
+func (s *Session) finish() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// Done returns a channel that is closed once the channel has hung up
+// (CHANNEL_HANGUP_COMPLETE) or the connection is gone.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Context returns the context of the call, see OutboundSocket.Context.
+func (s *Session) Context() context.Context {
+	return s.socket.Context()
+}
+
+// Socket returns the underlying outbound socket.
+func (s *Session) Socket() *OutboundSocket {
+	return s.socket
+}
+
+// Channel returns a snapshot of the channel headers: those received on
+// connect, updated by every event of the channel since.
+func (s *Session) Channel() *Event {
+	ev := &Event{Header: make(map[string]string)}
+	s.mtx.Lock()
+	for k, v := range s.channel {
+		ev.Header[k] = v
+	}
+	s.mtx.Unlock()
+	return ev
+}
+
+// header returns a channel header.
+func (s *Session) header(key string) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	v, _ := (&Event{Header: s.channel}).LookupHeader(key)
+	return v
+}
+
+// State returns the Channel-State, e.g. CS_EXECUTE.
+func (s *Session) State() string {
+	return s.header("Channel-State")
+}
+
+// CallState returns the Channel-Call-State, e.g. RINGING or ACTIVE.
+func (s *Session) CallState() string {
+	return s.header("Channel-Call-State")
+}
+
+// Variable returns the value of the channel variable name as last seen in
+// an event, or "" if it is not set.
+func (s *Session) Variable(name string) string {
+	return s.header("variable_" + name)
+}
+
+// Execute runs the dialplan application app with args on the channel.
+func (s *Session) Execute(ctx context.Context, app, args string) (*Event, error) {
+	return s.socket.ProtocolSendMsgContext(ctx, app, args, s.UUID, true, 0, false)
+}
+
+func (s *Session) Answer(ctx context.Context) (*Event, error) {
+	return s.socket.AnswerContext(ctx, s.UUID, true)
+}
+
+func (s *Session) PreAnswer(ctx context.Context) (*Event, error) {
+	return s.Execute(ctx, "pre_answer", "")
+}
+
+func (s *Session) RingReady(ctx context.Context) (*Event, error) {
+	return s.Execute(ctx, "ring_ready", "")
+}
+
+// Playback plays file. Set playback_terminators to make it interruptible.
+func (s *Session) Playback(ctx context.Context, file string) (*Event, error) {
+	return s.Execute(ctx, "playback", file)
+}
+
+func (s *Session) Speak(ctx context.Context, text string) (*Event, error) {
+	return s.socket.SpeakContext(ctx, text, s.UUID, true, 0)
+}
+
+// Bridge bridges the channel to the endpoints in dial string dest.
+func (s *Session) Bridge(ctx context.Context, dest string) (*Event, error) {
+	return s.socket.BridgeContext(ctx, dest, s.UUID, true)
+}
+
+// Transfer transfers the channel to "extension [dialplan [context]]".
+func (s *Session) Transfer(ctx context.Context, dest string) (*Event, error) {
+	return s.socket.TransferContext(ctx, dest, s.UUID, true)
+}
+
+// Set sets the channel variable name.
+func (s *Session) Set(ctx context.Context, name, value string) (*Event, error) {
+	return s.socket.SetContext(ctx, name+"="+value, s.UUID, true)
+}
+
+// Export sets the channel variable name on this channel and the channels
+// it gets bridged to.
+func (s *Session) Export(ctx context.Context, name, value string) (*Event, error) {
+	return s.socket.ExportContext(ctx, name+"="+value, s.UUID, true)
+}
+
+func (s *Session) Unset(ctx context.Context, name string) (*Event, error) {
+	return s.socket.UnsetContext(ctx, name, s.UUID, true)
+}
+
+// Sleep pauses the channel for d, at millisecond precision.
+func (s *Session) Sleep(ctx context.Context, d time.Duration) (*Event, error) {
+	return s.socket.SleepContext(ctx, strconv.FormatInt(int64(d/time.Millisecond), 10), s.UUID, true)
+}
+
+// Hangup hangs up the channel with cause, e.g. NORMAL_CLEARING. An empty
+// cause lets FreeSWITCH pick the default.
+func (s *Session) Hangup(ctx context.Context, cause string) (*Event, error) {
+	return s.socket.HangupContext(ctx, cause, s.UUID, true)
+}